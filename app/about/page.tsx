@@ -328,6 +328,11 @@ OP_RETURN
           https://bitpic.net/u/unknown@example.com?d=https://example.com/fallback.png
         </code>
 
+        <p>
+          Default image URLs must use https and be on a host the server
+          allows; other URLs are ignored.
+        </p>
+
         <hr className="my-8 border-border" />
 
         <h2 className="text-2xl font-bold tracking-tight">Protocol</h2>
@@ -0,0 +1,119 @@
+package bitpic
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitcoin-sv/go-templates/template/bitcom"
+	bsm "github.com/bsv-blockchain/go-sdk/compat/bsm"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+// Content is what an avatar record publishes: an embedded image or a
+// reference to existing content. Create it with Embed or Reference.
+type Content struct {
+	Data      []byte
+	MediaType string
+	Encoding  string
+	ref       bool
+}
+
+// Embed returns content for an image stored in the transaction itself.
+func Embed(image []byte, mediaType string) (*Content, error) {
+	if len(image) == 0 {
+		return nil, errors.New("image is empty")
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	return &Content{Data: image, MediaType: mediaType, Encoding: "binary"}, nil
+}
+
+// Reference returns content pointing at an existing inscription or B file,
+// as an ord://<txid>_<vout> or b://<txid>_<vout> URI, or at content by hash,
+// as c://<sha256>.
+func Reference(uri string) (*Content, error) {
+	return References(uri)
+}
+
+// References returns content pointing at several copies of the same avatar,
+// tried in order: a uri-list signed as a whole (see Reference for the URIs).
+func References(uris ...string) (*Content, error) {
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("%w: no URIs", ErrBadReference)
+	}
+	for _, uri := range uris {
+		if _, _, ok := ParseRefURI(uri); !ok || strings.ContainsAny(uri, "\r\n") {
+			return nil, fmt.Errorf("%w: %s", ErrBadReference, uri)
+		}
+	}
+	data := []byte(strings.Join(uris, "\r\n"))
+	return &Content{Data: data, MediaType: UriListMime, Encoding: "utf-8", ref: true}, nil
+}
+
+// message returns the bytes the BitPic signature covers: the SHA-256 of an
+// embedded image, or the reference uri-list itself.
+func (c *Content) message() []byte {
+	if c.ref {
+		return c.Data
+	}
+	hash := sha256.Sum256(c.Data)
+	return hash[:]
+}
+
+// Sign returns the BitPic tape's pubkey (compressed, hex) and BSM signature
+// (base64) over content, made with key.
+func Sign(content *Content, key *ec.PrivateKey) (pubKey, sig string, err error) {
+	sigBytes, err := bsm.SignMessage(key, content.message())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign content: %w", err)
+	}
+	return hex.EncodeToString(key.PubKey().Compressed()), base64.StdEncoding.EncodeToString(sigBytes), nil
+}
+
+// LockingScript builds the OP_RETURN script publishing content as paymail's
+// avatar, signed with key (the paymail's identity key). It is the layout
+// ParseTransaction reads:
+//
+//	OP_FALSE OP_RETURN
+//	  19Hxig… <data> <media-type> <encoding> | 18pAq… <paymail> <pubkey> <signature>
+func LockingScript(content *Content, paymail string, key *ec.PrivateKey) (*script.Script, error) {
+	if !strings.Contains(paymail, "@") {
+		return nil, fmt.Errorf("invalid paymail: %q", paymail)
+	}
+	pubKey, sig, err := Sign(content, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &script.Script{}
+	if err := s.AppendOpcodes(script.OpFALSE, script.OpRETURN); err != nil {
+		return nil, fmt.Errorf("failed to build script: %w", err)
+	}
+	for _, push := range [][]byte{
+		[]byte(bitcom.BPrefix), content.Data, []byte(content.MediaType), []byte(content.Encoding),
+		[]byte("|"),
+		[]byte(BitPicPrefix), []byte(paymail), []byte(pubKey), []byte(sig),
+	} {
+		if err := s.AppendPushData(push); err != nil {
+			return nil, fmt.Errorf("failed to build script: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// NewOutput returns a zero-satoshi output publishing content as paymail's
+// avatar. Add it to a transaction and fund and sign that as usual.
+func NewOutput(content *Content, paymail string, key *ec.PrivateKey) (*transaction.TransactionOutput, error) {
+	s, err := LockingScript(content, paymail, key)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction.TransactionOutput{LockingScript: s, Satoshis: 0}, nil
+}
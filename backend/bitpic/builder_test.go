@@ -0,0 +1,125 @@
+package bitpic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+func TestBuilderRoundTrip(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\nnot really a png")
+	imageHash := sha256.Sum256(image)
+	ord := "ord://" + strings.Repeat("1", 64) + "_0"
+	b := "b://" + strings.Repeat("2", 64) + "_1"
+	c := "c://" + strings.Repeat("ab", 32)
+
+	refs := func(uris ...string) func() (*Content, error) {
+		return func() (*Content, error) { return References(uris...) }
+	}
+
+	tests := []struct {
+		name       string
+		content    func() (*Content, error)
+		wantMedia  string
+		wantHash   string
+		wantOrigin string
+		wantURIs   []string
+		signed     []byte // what the signature covers
+	}{
+		{
+			name:      "embed",
+			content:   func() (*Content, error) { return Embed(image, "image/png") },
+			wantMedia: "image/png",
+			wantHash:  hex.EncodeToString(imageHash[:]),
+			signed:    imageHash[:],
+		},
+		{
+			name:       "ord://",
+			content:    func() (*Content, error) { return Reference(ord) },
+			wantMedia:  UriListMime,
+			wantOrigin: strings.Repeat("1", 64) + "_0",
+			wantURIs:   []string{ord},
+			signed:     []byte(ord),
+		},
+		{
+			name:       "b://",
+			content:    refs(b),
+			wantMedia:  UriListMime,
+			wantOrigin: strings.Repeat("2", 64) + "_1",
+			wantURIs:   []string{b},
+			signed:     []byte(b),
+		},
+		{
+			name:      "uri-list",
+			content:   refs(c, ord, b),
+			wantMedia: UriListMime,
+			wantHash:  strings.Repeat("ab", 32),
+			wantURIs:  []string{c, ord, b},
+			signed:    []byte(c + "\r\n" + ord + "\r\n" + b),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ec.NewPrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := tt.content()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := NewOutput(content, "alice@example.com", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx := transaction.NewTransaction()
+			tx.AddOutput(out)
+
+			data, err := ParseTransaction(tx.Bytes())
+			if err != nil {
+				t.Fatalf("ParseTransaction: %v", err)
+			}
+			if data.Paymail != "alice@example.com" || data.PubKey != hex.EncodeToString(key.PubKey().Compressed()) {
+				t.Errorf("paymail, pubkey = %q, %q", data.Paymail, data.PubKey)
+			}
+			if data.MediaType != tt.wantMedia || data.ImageHash != tt.wantHash {
+				t.Errorf("mediaType, imageHash = %q, %q; want %q, %q", data.MediaType, data.ImageHash, tt.wantMedia, tt.wantHash)
+			}
+			if data.IsRef != (tt.wantURIs != nil) || data.RefOrigin != tt.wantOrigin || !reflect.DeepEqual(data.RefURIs, tt.wantURIs) {
+				t.Errorf("isRef, refOrigin, refURIs = %v, %q, %v", data.IsRef, data.RefOrigin, data.RefURIs)
+			}
+			if err := VerifySignatureBytes(tt.signed, data.PubKey, data.Signature); err != nil {
+				t.Errorf("signature does not verify: %v", err)
+			}
+			if data.Outpoint != tx.TxID().String()+"_0" {
+				t.Errorf("outpoint = %q", data.Outpoint)
+			}
+		})
+	}
+}
+
+func TestBuilderRejects(t *testing.T) {
+	if _, err := Embed(nil, "image/png"); err == nil {
+		t.Error("Embed accepted an empty image")
+	}
+	if _, err := Embed([]byte{1}, "text/plain"); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("Embed(text/plain) error = %v, want ErrUnsupportedMediaType", err)
+	}
+	for _, uris := range [][]string{nil, {"https://example.com/a.png"}, {"ord://" + strings.Repeat("1", 64) + "_0\r\nb://x"}} {
+		if _, err := References(uris...); !errors.Is(err, ErrBadReference) {
+			t.Errorf("References(%q) error = %v, want ErrBadReference", uris, err)
+		}
+	}
+
+	key, _ := ec.NewPrivateKey()
+	content, _ := Reference("ord://" + strings.Repeat("1", 64) + "_0")
+	if _, err := LockingScript(content, "not-a-paymail", key); err == nil {
+		t.Error("LockingScript accepted a paymail without @")
+	}
+}
@@ -0,0 +1,61 @@
+package bitpic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons ParseTransaction rejects a transaction. Test with errors.Is.
+var (
+	// ErrNotBitPic: the transaction carries no BitPic tape at all.
+	ErrNotBitPic = errors.New("not a BitPic transaction")
+	// ErrMalformedTape: a BitPic tape is present but unreadable, or has no B
+	// record to go with it.
+	ErrMalformedTape = errors.New("malformed BitPic tape")
+	// ErrUnsupportedMediaType: the B record is neither an image nor a reference.
+	ErrUnsupportedMediaType = errors.New("unsupported BitPic media type")
+	// ErrBadReference: a reference avatar has no usable outpoint.
+	ErrBadReference = errors.New("invalid BitPic reference")
+	// ErrBadSignature: the signature doesn't verify against the tape's pubkey.
+	ErrBadSignature = errors.New("BitPic signature verification failed")
+)
+
+// ParseError is returned by ParseTransaction for a transaction that has a
+// BitPic tape but fails validation. It carries what could be read before the
+// failure, so a rejection can be reported against the paymail.
+type ParseError struct {
+	TxID    string
+	Paymail string // empty if the tape couldn't be read
+	Err     error  // wraps one of the Err* reasons
+}
+
+func (e *ParseError) Error() string {
+	if e.Paymail == "" {
+		return fmt.Sprintf("%s: %v", e.TxID, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %v", e.TxID, e.Paymail, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Reason returns a short stable code for a ParseTransaction error:
+// not_bitpic, malformed_tape, unsupported_media_type, bad_reference,
+// bad_signature, or invalid_tx for anything else.
+func Reason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotBitPic):
+		return "not_bitpic"
+	case errors.Is(err, ErrMalformedTape):
+		return "malformed_tape"
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return "unsupported_media_type"
+	case errors.Is(err, ErrBadReference):
+		return "bad_reference"
+	case errors.Is(err, ErrBadSignature):
+		return "bad_signature"
+	default:
+		return "invalid_tx"
+	}
+}
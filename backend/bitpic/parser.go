@@ -26,12 +26,27 @@ type BitPicData struct {
 	Paymail   string
 	PubKey    string
 	Signature string
-	ImageHash string // SHA256 of embedded image (hex); empty for references
+	MediaType string // B record media type (image/*, text/uri-list or the legacy ref type)
+	ImageHash string // SHA256 (hex) of the embedded image, or from a c:// reference
+	ImageSize int    // size of the embedded image in bytes; 0 for references
+	Image     []byte // the embedded image itself; nil for references
 	Outpoint  string // txid_vout of the BitPic output
 	TxID      string
 	Timestamp int64
 	IsRef     bool   // true when the avatar references existing content
-	RefOrigin string // txid_vout of the referenced content (for refs)
+	RefOrigin string // txid_vout of the referenced content (ord:// and b:// refs)
+
+	// Every signed URI of a uri-list, in order; the first is RefOrigin/ImageHash.
+	RefURIs []string
+}
+
+// Result is the outcome for one output of a transaction that carries a
+// BitPic tape: Data if it is valid, otherwise Err (a *ParseError).
+type Result struct {
+	Vout     uint32
+	Outpoint string
+	Data     *BitPicData
+	Err      error
 }
 
 // ParseTransaction extracts BitPic protocol data from a raw transaction.
@@ -42,88 +57,156 @@ type BitPicData struct {
 //	  19Hxig… (B)       <image|uri>  <media-type>  <encoding>
 //	  |
 //	  18pAq…  (BitPic)  <paymail>    <pubkey>      <signature>
+//
+// A transaction without a BitPic tape returns ErrNotBitPic; one whose tape
+// fails validation returns a *ParseError wrapping the reason (see errors.go).
+// Only the first well-formed BitPic output is read; see ParseTransactionAll.
 func ParseTransaction(txBytes []byte) (*BitPicData, error) {
+	results, err := ParseTransactionAll(txBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// A malformed tape is only reported if no other output holds a valid one.
+	for _, r := range results {
+		if !errors.Is(r.Err, ErrMalformedTape) {
+			return r.Data, r.Err
+		}
+	}
+	return nil, results[0].Err
+}
+
+// ParseTransactionAll returns a Result for every output of a raw transaction
+// that carries a BitPic tape, in output order, so a batch transaction can set
+// avatars for several paymails. A transaction without a BitPic tape returns
+// ErrNotBitPic.
+func ParseTransactionAll(txBytes []byte) ([]Result, error) {
 	tx, err := transaction.NewTransactionFromBytes(txBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse transaction: %w", err)
 	}
 
 	txid := tx.TxID().String()
-
+	var results []Result
 	for i, output := range tx.Outputs {
-		bc := bitcom.Decode(output.LockingScript)
-		if bc == nil || len(bc.Protocols) == 0 {
+		data, err := parseOutput(txid, i, output)
+		if data == nil && err == nil {
 			continue
 		}
+		results = append(results, Result{
+			Vout:     uint32(i),
+			Outpoint: fmt.Sprintf("%s_%d", txid, i),
+			Data:     data,
+			Err:      err,
+		})
+	}
 
-		var b *bitcom.B
-		var paymail, pubKey, sig string
-		bitpicFound := false
-
-		for _, proto := range bc.Protocols {
-			switch proto.Protocol {
-			case bitcom.BPrefix:
-				b = bitcom.DecodeB(proto.Script)
-			case BitPicPrefix:
-				if p, pk, s, ok := parseBitPicTape(proto.Script); ok {
-					paymail, pubKey, sig = p, pk, s
-					bitpicFound = true
-				}
-			}
-		}
+	if len(results) == 0 {
+		return nil, ErrNotBitPic
+	}
+	return results, nil
+}
 
-		if !bitpicFound || b == nil || len(b.Data) == 0 {
-			continue
-		}
+// parseOutput reads output i of transaction txid. It returns nil, nil if the
+// output has no BitPic tape.
+func parseOutput(txid string, i int, output *transaction.TransactionOutput) (*BitPicData, error) {
+	bc := bitcom.Decode(output.LockingScript)
+	if bc == nil || len(bc.Protocols) == 0 {
+		return nil, nil
+	}
 
-		data := &BitPicData{
-			TxID:      txid,
-			Outpoint:  fmt.Sprintf("%s_%d", txid, i),
-			Paymail:   paymail,
-			PubKey:    pubKey,
-			Signature: sig,
-		}
+	var b *bitcom.B
+	var paymail, pubKey, sig string
+	bitpicFound := false
+	tapeSeen := false
 
-		mediaType := string(b.MediaType)
-		switch {
-		case mediaType == UriListMime:
-			uri, refOrigin, ok := parseUriListRef(b.Data)
-			if !ok {
-				return nil, errors.New("no resolvable ord:// or b:// reference in uri-list")
-			}
-			data.IsRef = true
-			data.RefOrigin = refOrigin
-			if err := VerifySignatureBytes([]byte(uri), pubKey, sig); err != nil {
-				return nil, fmt.Errorf("signature verification failed: %w", err)
+	for _, proto := range bc.Protocols {
+		switch proto.Protocol {
+		case bitcom.BPrefix:
+			b = bitcom.DecodeB(proto.Script)
+		case BitPicPrefix:
+			tapeSeen = true
+			if p, pk, s, ok := parseBitPicTape(proto.Script); ok {
+				paymail, pubKey, sig = p, pk, s
+				bitpicFound = true
 			}
-			return data, nil
+		}
+	}
 
-		case mediaType == LegacyRefMime:
-			refOrigin := normalizeOutpoint(string(b.Data))
-			if !isValidOutpoint(refOrigin) {
-				return nil, fmt.Errorf("invalid ordinal reference format: %s", refOrigin)
+	if !tapeSeen {
+		return nil, nil
+	}
+	if !bitpicFound {
+		return nil, &ParseError{TxID: txid,
+			Err: fmt.Errorf("%w: expected paymail, pubkey and signature", ErrMalformedTape)}
+	}
+	if b == nil || len(b.Data) == 0 {
+		return nil, &ParseError{TxID: txid, Paymail: paymail,
+			Err: fmt.Errorf("%w: missing or empty B record", ErrMalformedTape)}
+	}
+
+	data := &BitPicData{
+		TxID:      txid,
+		Outpoint:  fmt.Sprintf("%s_%d", txid, i),
+		Paymail:   paymail,
+		PubKey:    pubKey,
+		Signature: sig,
+		MediaType: string(b.MediaType),
+	}
+	reject := func(err error) (*BitPicData, error) {
+		return nil, &ParseError{TxID: txid, Paymail: paymail, Err: err}
+	}
+
+	mediaType := data.MediaType
+	switch {
+	case mediaType == UriListMime:
+		uris := parseUriList(b.Data)
+		if len(uris) == 0 {
+			return reject(fmt.Errorf("%w: no resolvable ord://, b:// or c:// reference in uri-list", ErrBadReference))
+		}
+		// The signature covers the whole list, making every URI in it a
+		// fallback; records signed over just the first ord:// or b:// URI
+		// predate that (and c:// lines, which older signers skipped).
+		if err := VerifySignatureBytes(b.Data, pubKey, sig); err != nil {
+			legacy := firstOutpointURI(uris)
+			if legacy == "" {
+				return reject(fmt.Errorf("%w: %w", ErrBadSignature, err))
 			}
-			data.IsRef = true
-			data.RefOrigin = refOrigin
-			if err := VerifySignatureBytes([]byte(string(b.Data)), pubKey, sig); err != nil {
-				return nil, fmt.Errorf("signature verification failed: %w", err)
+			if err := VerifySignatureBytes([]byte(legacy), pubKey, sig); err != nil {
+				return reject(fmt.Errorf("%w: %w", ErrBadSignature, err))
 			}
-			return data, nil
+			uris = []string{legacy}
+		}
+		data.IsRef = true
+		data.RefURIs = uris
+		data.RefOrigin, data.ImageHash, _ = ParseRefURI(uris[0])
+		return data, nil
 
-		case strings.HasPrefix(mediaType, "image/"):
-			hash := sha256.Sum256(b.Data)
-			data.ImageHash = hex.EncodeToString(hash[:])
-			if err := VerifySignatureBytes(hash[:], pubKey, sig); err != nil {
-				return nil, fmt.Errorf("signature verification failed: %w", err)
-			}
-			return data, nil
+	case mediaType == LegacyRefMime:
+		refOrigin := normalizeOutpoint(string(b.Data))
+		if !isValidOutpoint(refOrigin) {
+			return reject(fmt.Errorf("%w: invalid ordinal reference format: %s", ErrBadReference, refOrigin))
+		}
+		data.IsRef = true
+		data.RefOrigin = refOrigin
+		if err := VerifySignatureBytes([]byte(string(b.Data)), pubKey, sig); err != nil {
+			return reject(fmt.Errorf("%w: %w", ErrBadSignature, err))
+		}
+		return data, nil
 
-		default:
-			return nil, fmt.Errorf("unsupported BitPic media type: %s", mediaType)
+	case strings.HasPrefix(mediaType, "image/"):
+		hash := sha256.Sum256(b.Data)
+		data.ImageHash = hex.EncodeToString(hash[:])
+		data.ImageSize = len(b.Data)
+		data.Image = b.Data
+		if err := VerifySignatureBytes(hash[:], pubKey, sig); err != nil {
+			return reject(fmt.Errorf("%w: %w", ErrBadSignature, err))
 		}
-	}
+		return data, nil
 
-	return nil, errors.New("BitPic protocol data not found in transaction")
+	default:
+		return reject(fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType))
+	}
 }
 
 // parseBitPicTape reads the BitPic tape's pushdata: paymail, pubkey, signature.
@@ -135,30 +218,55 @@ func parseBitPicTape(scriptBytes []byte) (paymail, pubKey, sig string, ok bool)
 	return string(chunks[0].Data), string(chunks[1].Data), string(chunks[2].Data), true
 }
 
-// parseUriListRef returns the first resolvable URI (ord:// or b://) and its
-// normalized txid_vout. c://, https:// and other schemes are skipped (no
-// indexer). Comment lines (#) and blanks are ignored.
-func parseUriListRef(data []byte) (uri, refOrigin string, ok bool) {
+// parseUriList returns the resolvable URIs in a uri-list, in order. Comment
+// lines (#) and blanks are ignored, as are URIs ParseRefURI can't resolve.
+func parseUriList(data []byte) []string {
+	var uris []string
 	for _, line := range strings.Split(string(data), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		var raw string
-		switch {
-		case strings.HasPrefix(line, "ord://"):
-			raw = strings.TrimPrefix(line, "ord://")
-		case strings.HasPrefix(line, "b://"):
-			raw = strings.TrimPrefix(line, "b://")
-		default:
-			continue
+		if _, _, ok := ParseRefURI(line); ok {
+			uris = append(uris, line)
 		}
-		outpoint := normalizeOutpoint(raw)
-		if isValidOutpoint(outpoint) {
-			return line, outpoint, true
+	}
+	return uris
+}
+
+// firstOutpointURI returns the first ord:// or b:// URI in uris, or "".
+func firstOutpointURI(uris []string) string {
+	for _, uri := range uris {
+		if refOrigin, _, _ := ParseRefURI(uri); refOrigin != "" {
+			return uri
+		}
+	}
+	return ""
+}
+
+// ParseRefURI resolves a reference URI: ord:// or b:// to its normalized
+// txid_vout, or c:// to its SHA-256 (lowercase hex). https:// and other
+// schemes aren't resolvable (nothing to verify them against).
+func ParseRefURI(uri string) (refOrigin, contentHash string, ok bool) {
+	var raw string
+	switch {
+	case strings.HasPrefix(uri, "ord://"):
+		raw = strings.TrimPrefix(uri, "ord://")
+	case strings.HasPrefix(uri, "b://"):
+		raw = strings.TrimPrefix(uri, "b://")
+	case strings.HasPrefix(uri, "c://"):
+		if hash := strings.ToLower(strings.TrimPrefix(uri, "c://")); isValidHash(hash) {
+			return "", hash, true
 		}
+		return "", "", false
+	default:
+		return "", "", false
+	}
+	outpoint := normalizeOutpoint(raw)
+	if !isValidOutpoint(outpoint) {
+		return "", "", false
 	}
-	return "", "", false
+	return outpoint, "", true
 }
 
 // normalizeOutpoint converts txid.vout to txid_vout.
@@ -169,6 +277,15 @@ func normalizeOutpoint(outpoint string) string {
 	return outpoint
 }
 
+// isValidHash validates a hex SHA-256.
+func isValidHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
 // isValidOutpoint validates a txid_vout reference.
 func isValidOutpoint(outpoint string) bool {
 	parts := strings.Split(outpoint, "_")
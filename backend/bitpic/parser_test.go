@@ -0,0 +1,92 @@
+package bitpic
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitcoin-sv/go-templates/template/bitcom"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+// uriListTx returns a transaction whose BitPic output publishes list, with
+// the signature made over signed rather than the list itself
+func uriListTx(t *testing.T, list, signed string) []byte {
+	t.Helper()
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, sig, err := Sign(&Content{Data: []byte(signed), ref: true}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &script.Script{}
+	s.AppendOpcodes(script.OpFALSE, script.OpRETURN)
+	for _, push := range []string{bitcom.BPrefix, list, UriListMime, "utf-8", "|", BitPicPrefix, "alice@example.com", pubKey, sig} {
+		if err := s.AppendPushData([]byte(push)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx := transaction.NewTransaction()
+	tx.AddOutput(&transaction.TransactionOutput{LockingScript: s})
+	return tx.Bytes()
+}
+
+func TestParseUriListSignatures(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	ord := "ord://" + strings.Repeat("1", 64) + "_0"
+	b := "b://" + strings.Repeat("2", 64) + "_1"
+	list := strings.Join([]string{"c://" + hash, ord, b}, "\r\n")
+
+	tests := []struct {
+		name       string
+		signed     string
+		wantURIs   []string
+		wantOrigin string
+		wantHash   string
+	}{
+		{
+			name:     "whole list",
+			signed:   list,
+			wantURIs: []string{"c://" + hash, ord, b},
+			wantHash: hash,
+		},
+		{
+			// Older signers signed the first ord:// or b:// line only
+			name:       "legacy list starting with c://",
+			signed:     ord,
+			wantURIs:   []string{ord},
+			wantOrigin: strings.Repeat("1", 64) + "_0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ParseTransaction(uriListTx(t, list, tt.signed))
+			if err != nil {
+				t.Fatalf("ParseTransaction: %v", err)
+			}
+			if !data.IsRef || !reflect.DeepEqual(data.RefURIs, tt.wantURIs) {
+				t.Errorf("RefURIs = %v, want %v", data.RefURIs, tt.wantURIs)
+			}
+			if data.RefOrigin != tt.wantOrigin || data.ImageHash != tt.wantHash {
+				t.Errorf("RefOrigin, ImageHash = %q, %q; want %q, %q", data.RefOrigin, data.ImageHash, tt.wantOrigin, tt.wantHash)
+			}
+		})
+	}
+
+	for name, signed := range map[string]string{
+		"c:// line only":  "c://" + hash,
+		"later b:// line": b,
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := ParseTransaction(uriListTx(t, list, signed)); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("ParseTransaction error = %v, want ErrBadSignature", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+// Package bsvalias checks that the key signing a BitPic tape belongs to the
+// paymail it is for, by asking the paymail's host: the verify-pubkey
+// capability (BRFC a9f510c16bde) if it offers one, its PKI endpoint otherwise.
+package bsvalias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/b-open-io/bitpic/storage"
+)
+
+// Avatar ownership states (storage.AvatarData.Owner). Empty means unchecked.
+const (
+	OwnerVerified = "verified" // the paymail's host vouches for the signing key
+	OwnerMismatch = "mismatch" // the host reports a different key
+	OwnerUnknown  = "unknown"  // the host couldn't be asked
+)
+
+// ErrNotOwner: ownership is required and the signing key isn't verified as
+// the paymail's.
+var ErrNotOwner = errors.New("paymail owner not verified")
+
+// Reason is the rejection code for ErrNotOwner, alongside bitpic.Reason's.
+const Reason = "unverified_owner"
+
+// Capability keys in a bsvalias capabilities document.
+const (
+	capPKI          = "pki"
+	capVerifyPubKey = "a9f510c16bde"
+)
+
+// maxCacheEntries is the size past which expired cache entries are dropped.
+const maxCacheEntries = 10000
+
+// failureTTL is how long a failed lookup is cached, so a dead or slow host
+// costs the indexer one timeout a minute rather than one per transaction.
+const failureTTL = time.Minute
+
+// HostResolver returns the base URL (scheme://host[:port]) of the bsvalias
+// host for a paymail domain.
+type HostResolver func(ctx context.Context, domain string) (string, error)
+
+// Verifier resolves paymail ownership, caching answers (verified or not)
+// and capability documents for cacheTTL, and failures for failureTTL.
+//
+// Paymail domains, their SRV targets and capability URLs are chosen by
+// whoever publishes an avatar, so requests go over https only and never to
+// loopback, private or link-local addresses.
+type Verifier struct {
+	client   *http.Client
+	cacheTTL time.Duration
+	require  bool
+	resolve  HostResolver
+
+	// Paymails at localDomain are registered here: checked against store.
+	localDomain string
+	local       storage.Store
+
+	mu           sync.Mutex
+	capabilities map[string]cached[map[string]string] // by domain
+	results      map[string]cached[bool]              // by paymail + pubkey
+}
+
+type cached[T any] struct {
+	value   T
+	err     error
+	expires time.Time
+}
+
+// NewVerifier creates a verifier whose requests are bounded by timeout. With
+// require set, Check fails for any avatar whose owner isn't verified.
+func NewVerifier(timeout, cacheTTL time.Duration, require bool) *Verifier {
+	return &Verifier{
+		client:       newClient(timeout),
+		cacheTTL:     cacheTTL,
+		require:      require,
+		resolve:      LookupHost,
+		capabilities: make(map[string]cached[map[string]string]),
+		results:      make(map[string]cached[bool]),
+	}
+}
+
+// ForPolicy returns a verifier for an ownership policy: nil for "off" (or
+// ""), one recording each avatar's state for "record", one also rejecting
+// unverified avatars for "require".
+func ForPolicy(policy string, timeout, cacheTTL time.Duration) (*Verifier, error) {
+	switch policy {
+	case "", "off":
+		return nil, nil
+	case "record", "require":
+		return NewVerifier(timeout, cacheTTL, policy == "require"), nil
+	default:
+		return nil, fmt.Errorf("unknown ownership policy %q (want off, record or require)", policy)
+	}
+}
+
+// SetHostResolver replaces how bsvalias hosts are found (LookupHost by
+// default), e.g. to point every domain at a local fake host.
+func (v *Verifier) SetHostResolver(resolve HostResolver) {
+	v.resolve = resolve
+}
+
+// SetHTTPClient replaces the client requests are made with, which by default
+// refuses to dial non-public addresses, e.g. to reach a local fake host.
+func (v *Verifier) SetHTTPClient(client *http.Client) {
+	v.client = client
+}
+
+// SetLocal makes paymails at domain resolve against the identity keys
+// registered in store instead of over HTTP.
+func (v *Verifier) SetLocal(domain string, store storage.Store) {
+	v.localDomain = strings.ToLower(domain)
+	v.local = store
+}
+
+// newClient returns an http client that only makes https requests to public
+// addresses, bounded by timeout. Proxies are not used: they would hide the
+// address actually dialed.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicOnly}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing redirect to %s: not https", req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+}
+
+// publicOnly is a net.Dialer Control refusing connections to loopback,
+// private, link-local and other non-public addresses. It sees the address
+// after DNS resolution, so a public name pointing inward is caught too.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to dial non-public address %s", host)
+	}
+	return nil
+}
+
+// LookupHost finds a domain's bsvalias host from its _bsvalias._tcp SRV
+// record, falling back to the domain itself on port 443.
+func LookupHost(ctx context.Context, domain string) (string, error) {
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "bsvalias", "tcp", domain)
+	if err != nil || len(srvs) == 0 {
+		return "https://" + domain, nil
+	}
+	target := strings.TrimSuffix(srvs[0].Target, ".")
+	return fmt.Sprintf("https://%s:%d", target, srvs[0].Port), nil
+}
+
+// Check returns the ownership state of an avatar for paymail signed with
+// pubKey. If ownership is required and not verified, it also returns an
+// error wrapping ErrNotOwner.
+func (v *Verifier) Check(ctx context.Context, paymail, pubKey string) (string, error) {
+	ok, err := v.Verify(ctx, paymail, pubKey)
+	switch {
+	case err != nil:
+		if v.require {
+			return OwnerUnknown, fmt.Errorf("%w: %w", ErrNotOwner, err)
+		}
+		return OwnerUnknown, nil
+	case !ok:
+		if v.require {
+			return OwnerMismatch, fmt.Errorf("%w: %s is not %s's key", ErrNotOwner, pubKey, paymail)
+		}
+		return OwnerMismatch, nil
+	}
+	return OwnerVerified, nil
+}
+
+// Verify reports whether pubKey (compressed, hex) is paymail's identity key.
+// An error means the paymail's host couldn't give an answer.
+func (v *Verifier) Verify(ctx context.Context, paymail, pubKey string) (bool, error) {
+	alias, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(paymail)), "@")
+	if !ok || alias == "" || domain == "" {
+		return false, fmt.Errorf("invalid paymail: %q", paymail)
+	}
+	pubKey = strings.ToLower(pubKey)
+
+	key := alias + "@" + domain + "|" + pubKey
+	v.mu.Lock()
+	if c, ok := v.results[key]; ok && time.Now().Before(c.expires) {
+		v.mu.Unlock()
+		return c.value, c.err
+	}
+	v.mu.Unlock()
+
+	var match bool
+	var err error
+	local := v.local != nil && domain == v.localDomain
+	if local {
+		match, err = v.verifyLocal(alias, pubKey)
+	} else {
+		match, err = v.verifyRemote(ctx, alias, domain, pubKey)
+	}
+	entry := cached[bool]{value: match, expires: time.Now().Add(v.cacheTTL)}
+	if err != nil {
+		// Only the remote host's failures are cached, not our store's or
+		// our own deadline
+		if local || ctx.Err() != nil {
+			return false, err
+		}
+		entry = cached[bool]{err: err, expires: time.Now().Add(min(failureTTL, v.cacheTTL))}
+	}
+
+	v.mu.Lock()
+	if len(v.results) >= maxCacheEntries {
+		pruneExpired(v.results)
+	}
+	v.results[key] = entry
+	v.mu.Unlock()
+	return match, err
+}
+
+// verifyLocal checks a paymail registered here
+func (v *Verifier) verifyLocal(alias, pubKey string) (bool, error) {
+	registered, err := v.local.GetPaymail(alias)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up paymail: %w", err)
+	}
+	return registered != nil && strings.EqualFold(registered.IdentityPubkey, pubKey), nil
+}
+
+// verifyRemote asks the paymail's host, preferring verify-pubkey over PKI
+func (v *Verifier) verifyRemote(ctx context.Context, alias, domain, pubKey string) (bool, error) {
+	caps, err := v.getCapabilities(ctx, domain)
+	if err != nil {
+		return false, err
+	}
+
+	if tmpl := caps[capVerifyPubKey]; tmpl != "" {
+		var resp struct {
+			Match bool `json:"match"`
+		}
+		if err := v.getJSON(ctx, expand(tmpl, alias, domain, pubKey), &resp); err != nil {
+			return false, fmt.Errorf("failed to verify pubkey: %w", err)
+		}
+		return resp.Match, nil
+	}
+
+	if tmpl := caps[capPKI]; tmpl != "" {
+		var resp struct {
+			PubKey string `json:"pubkey"`
+		}
+		if err := v.getJSON(ctx, expand(tmpl, alias, domain, pubKey), &resp); err != nil {
+			return false, fmt.Errorf("failed to fetch PKI: %w", err)
+		}
+		return strings.EqualFold(resp.PubKey, pubKey), nil
+	}
+
+	return false, fmt.Errorf("%s offers no PKI or verify-pubkey capability", domain)
+}
+
+// getCapabilities returns a domain's capability URL templates
+func (v *Verifier) getCapabilities(ctx context.Context, domain string) (map[string]string, error) {
+	v.mu.Lock()
+	if c, ok := v.capabilities[domain]; ok && time.Now().Before(c.expires) {
+		v.mu.Unlock()
+		return c.value, c.err
+	}
+	v.mu.Unlock()
+
+	caps, err := v.fetchCapabilities(ctx, domain)
+	entry := cached[map[string]string]{value: caps, expires: time.Now().Add(v.cacheTTL)}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err // our deadline, not the host's failure
+		}
+		entry = cached[map[string]string]{err: err, expires: time.Now().Add(min(failureTTL, v.cacheTTL))}
+	}
+
+	v.mu.Lock()
+	if len(v.capabilities) >= maxCacheEntries {
+		pruneExpired(v.capabilities)
+	}
+	v.capabilities[domain] = entry
+	v.mu.Unlock()
+	return caps, err
+}
+
+// fetchCapabilities finds a domain's bsvalias host and reads its capabilities
+func (v *Verifier) fetchCapabilities(ctx context.Context, domain string) (map[string]string, error) {
+	base, err := v.resolve(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find bsvalias host for %s: %w", domain, err)
+	}
+	var doc struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := v.getJSON(ctx, strings.TrimRight(base, "/")+"/.well-known/bsvalias", &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch capabilities for %s: %w", domain, err)
+	}
+	caps := make(map[string]string)
+	for k, val := range doc.Capabilities {
+		if s, ok := val.(string); ok {
+			caps[k] = s
+		}
+	}
+	return caps, nil
+}
+
+// getJSON GETs rawURL, which must be https, into out
+func (v *Verifier) getJSON(ctx context.Context, rawURL string, out any) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("refusing to fetch %s: not an https URL", u.Redacted())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u.Redacted(), resp.Status)
+	}
+	// Capability documents and PKI answers are small.
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", u.Redacted(), err)
+	}
+	return nil
+}
+
+// expand fills a capability URL template, escaping each value as a path
+// segment
+func expand(tmpl, alias, domain, pubKey string) string {
+	return strings.NewReplacer(
+		"{alias}", url.PathEscape(alias),
+		"{domain.tld}", url.PathEscape(domain),
+		"{pubkey}", url.PathEscape(pubKey),
+	).Replace(tmpl)
+}
+
+func pruneExpired[T any](m map[string]cached[T]) {
+	now := time.Now()
+	for k, c := range m {
+		if now.After(c.expires) {
+			delete(m, k)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package bsvalias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	aliceKey = "02aa00000000000000000000000000000000000000000000000000000000000001"
+	otherKey = "02bb00000000000000000000000000000000000000000000000000000000000002"
+)
+
+// fakeHost is a bsvalias host serving a PKI capability, and verify-pubkey if
+// verifyPubKey is set. With fail set, every request returns 500.
+type fakeHost struct {
+	*httptest.Server
+	keys         map[string]string // alias@domain -> identity key
+	verifyPubKey bool
+	fail         bool
+
+	mu    sync.Mutex
+	paths []string // escaped request paths, in order
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	t.Helper()
+	h := &fakeHost{keys: map[string]string{"alice@example.com": aliceKey}}
+	h.Server = httptest.NewTLSServer(http.HandlerFunc(h.serve))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.paths = append(h.paths, r.URL.EscapedPath())
+	h.mu.Unlock()
+	if h.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/.well-known/bsvalias":
+		caps := map[string]string{capPKI: h.URL + "/id/{alias}@{domain.tld}"}
+		if h.verifyPubKey {
+			caps[capVerifyPubKey] = h.URL + "/verify/{alias}@{domain.tld}/{pubkey}"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"bsvalias": "1.0", "capabilities": caps})
+	case strings.HasPrefix(path, "/id/"):
+		key, ok := h.keys[strings.TrimPrefix(path, "/id/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"bsvalias": "1.0", "pubkey": key})
+	case strings.HasPrefix(path, "/verify/"):
+		paymail, pubKey, _ := strings.Cut(strings.TrimPrefix(path, "/verify/"), "/")
+		json.NewEncoder(w).Encode(map[string]any{"match": h.keys[paymail] == pubKey})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *fakeHost) requests() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.paths...)
+}
+
+// verifierFor returns a verifier sending every domain to host
+func verifierFor(host *fakeHost, require bool) *Verifier {
+	v := NewVerifier(time.Second, time.Hour, require)
+	v.SetHTTPClient(host.Client())
+	v.SetHostResolver(func(context.Context, string) (string, error) { return host.URL, nil })
+	return v
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		verifyPubKey bool
+		require      bool
+		paymail      string
+		pubKey       string
+		want         string
+		wantErr      bool
+	}{
+		{name: "pki match", paymail: "alice@example.com", pubKey: aliceKey, want: OwnerVerified},
+		{name: "pki mismatch", paymail: "alice@example.com", pubKey: otherKey, want: OwnerMismatch},
+		{name: "pki mismatch required", require: true, paymail: "alice@example.com", pubKey: otherKey, want: OwnerMismatch, wantErr: true},
+		{name: "unknown alias", paymail: "bob@example.com", pubKey: aliceKey, want: OwnerUnknown},
+		{name: "unknown alias required", require: true, paymail: "bob@example.com", pubKey: aliceKey, want: OwnerUnknown, wantErr: true},
+		{name: "verify-pubkey match", verifyPubKey: true, paymail: "Alice@Example.com", pubKey: aliceKey, want: OwnerVerified},
+		{name: "verify-pubkey mismatch", verifyPubKey: true, paymail: "alice@example.com", pubKey: otherKey, want: OwnerMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := newFakeHost(t)
+			host.verifyPubKey = tt.verifyPubKey
+			owner, err := verifierFor(host, tt.require).Check(context.Background(), tt.paymail, tt.pubKey)
+			if owner != tt.want {
+				t.Errorf("owner = %q, want %q", owner, tt.want)
+			}
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrNotOwner)) {
+				t.Errorf("err = %v, want ErrNotOwner: %v", err, tt.wantErr)
+			}
+			for _, path := range host.requests() {
+				if tt.verifyPubKey && strings.HasPrefix(path, "/id/") {
+					t.Errorf("PKI fetched although verify-pubkey is offered")
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyEscapesTemplateValues(t *testing.T) {
+	host := newFakeHost(t)
+	host.keys["a/b?c@example.com"] = aliceKey
+
+	ok, err := verifierFor(host, false).Verify(context.Background(), "a/b?c@example.com", aliceKey)
+	if err != nil || !ok {
+		t.Fatalf("Verify = %v, %v; want a match", ok, err)
+	}
+	if paths := host.requests(); paths[len(paths)-1] != "/id/a%2Fb%3Fc@example.com" {
+		t.Errorf("requested %v, want the alias escaped", paths)
+	}
+}
+
+func TestVerifyCachesAnswersAndFailures(t *testing.T) {
+	host := newFakeHost(t)
+	v := verifierFor(host, false)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, err := v.Verify(ctx, "alice@example.com", aliceKey); err != nil || !ok {
+			t.Fatalf("Verify = %v, %v", ok, err)
+		}
+	}
+	if n := len(host.requests()); n != 2 {
+		t.Errorf("%d requests for a cached answer, want 2 (capabilities, PKI)", n)
+	}
+
+	down := newFakeHost(t)
+	down.fail = true
+	v = verifierFor(down, false)
+	for _, paymail := range []string{"alice@example.com", "alice@example.com", "carol@example.com"} {
+		if _, err := v.Verify(ctx, paymail, aliceKey); err == nil {
+			t.Fatalf("Verify(%s) against a failing host succeeded", paymail)
+		}
+	}
+	if n := len(down.requests()); n != 1 {
+		t.Errorf("failing host asked %d times, want 1 until the failure expires", n)
+	}
+}
+
+func TestVerifyRefusesUnsafeTargets(t *testing.T) {
+	host := newFakeHost(t)
+
+	// The default client won't dial the loopback test server
+	v := NewVerifier(time.Second, time.Hour, false)
+	v.SetHostResolver(func(context.Context, string) (string, error) { return host.URL, nil })
+	if _, err := v.Verify(context.Background(), "alice@example.com", aliceKey); err == nil || !strings.Contains(err.Error(), "non-public") {
+		t.Errorf("Verify error = %v, want a refused dial", err)
+	}
+
+	// Nor fetch plain http, whatever the client
+	v = verifierFor(host, false)
+	v.SetHostResolver(func(context.Context, string) (string, error) {
+		return strings.Replace(host.URL, "https://", "http://", 1), nil
+	})
+	if _, err := v.Verify(context.Background(), "alice@example.com", aliceKey); err == nil || !strings.Contains(err.Error(), "not an https URL") {
+		t.Errorf("Verify error = %v, want http refused", err)
+	}
+	if n := len(host.requests()); n != 0 {
+		t.Errorf("host received %d requests", n)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	for addr, allowed := range map[string]bool{
+		"93.184.216.34:443":      true,
+		"[2606:4700::1111]:443":  true,
+		"127.0.0.1:443":          false,
+		"10.1.2.3:443":           false,
+		"172.16.0.1:443":         false,
+		"192.168.1.1:443":        false,
+		"169.254.169.254:80":     false,
+		"0.0.0.0:443":            false,
+		"[::1]:443":              false,
+		"[fe80::1]:443":          false,
+		"[fd00::1]:443":          false,
+		"[::ffff:127.0.0.1]:443": false,
+	} {
+		if err := publicOnly("tcp", addr, nil); (err == nil) != allowed {
+			t.Errorf("publicOnly(%s) = %v, want allowed %v", addr, err, allowed)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Command bitpic is the operator's tool for a BitPic index: it verifies and
+// builds BitPic transactions, dumps and restores the avatar index, re-indexes
+// block ranges and looks up paymails, without going through the HTTP API.
+//
+//	bitpic verify <tx-file>                          parse and verify a raw tx or BEEF
+//	bitpic publish -paymail <p> -key <wif> <image>   build a signed avatar output
+//	bitpic publish -paymail <p> -key <wif> -ref <uri> [-ref <fallback>…]
+//	bitpic publish -paymail <p> -key <wif> -by-hash <image>
+//	bitpic export [-o file]                          dump the avatar index
+//	bitpic import [file]                             restore a dump
+//	bitpic reindex -from <h> -to <h>                 re-index a block range
+//	bitpic lookup [-history] <paymail>               show a paymail's avatar
+//
+// Storage and chain source settings come from the same environment variables
+// (and .env) as the server, and can be overridden with flags.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/bsvalias"
+	"github.com/b-open-io/bitpic/junglebus"
+	"github.com/b-open-io/bitpic/storage"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+	"github.com/joho/godotenv"
+)
+
+// command is a subcommand: it parses its own flags from args.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"verify":  {"verify <tx-file|->", runVerify},
+	"publish": {"publish -paymail <paymail> -key <wif> ([-by-hash [-content url]] <image-file> | -ref <uri>…)", runPublish},
+	"export":  {"export [-storage url] [-o file]", runExport},
+	"import":  {"import [-storage url] [file]", runImport},
+	"reindex": {"reindex [-storage url] [-content url] [-owner policy] [-replay path] -from <height> -to <height>", runReindex},
+	"lookup":  {"lookup [-storage url] [-history] <paymail>", runLookup},
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "bitpic %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range []string{"verify", "publish", "export", "import", "reindex", "lookup"} {
+		fmt.Fprintf(os.Stderr, "  bitpic %s\n", commands[name].usage)
+	}
+}
+
+// runVerify parses and verifies a BitPic transaction, printing the record
+// each BitPic output carries, or why it was rejected.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected one transaction file (- for stdin)")
+	}
+
+	raw, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	tx, err := decodeTx(raw)
+	if err != nil {
+		return err
+	}
+	results, err := bitpic.ParseTransactionAll(tx.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: %w", bitpic.Reason(err), err)
+	}
+
+	type output struct {
+		Outpoint string             `json:"outpoint"`
+		Data     *bitpic.BitPicData `json:"data,omitempty"`
+		Reason   string             `json:"reason,omitempty"`
+		Error    string             `json:"error,omitempty"`
+	}
+	outputs := make([]output, len(results))
+	valid := 0
+	for i, r := range results {
+		outputs[i] = output{Outpoint: r.Outpoint, Data: r.Data, Reason: bitpic.Reason(r.Err)}
+		if r.Err != nil {
+			outputs[i].Error = r.Err.Error()
+			continue
+		}
+		r.Data.Image = nil // ImageSize and ImageHash describe it
+		valid++
+	}
+	if err := printJSON(outputs); err != nil {
+		return err
+	}
+	if valid == 0 {
+		return errors.New("no valid BitPic output")
+	}
+	return nil
+}
+
+// runPublish builds a signed BitPic output for an image file or a reference.
+func runPublish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	paymail := fs.String("paymail", "", "paymail the avatar is for")
+	wif := fs.String("key", os.Getenv("BITPIC_KEY"), "paymail identity key (WIF); defaults to $BITPIC_KEY")
+	var refs []string
+	fs.Func("ref", "publish a reference (ord://…, b://… or c://<sha256>) instead of an image; repeat for fallbacks", func(uri string) error {
+		refs = append(refs, uri)
+		return nil
+	})
+	mediaType := fs.String("type", "", "image media type (detected from the file by default)")
+	byHash := fs.Bool("by-hash", false, "publish the image as a c://<sha256> reference instead of embedding it")
+	contentURL := fs.String("content", os.Getenv("CONTENT_STORE_URL"), "content store URL to put a -by-hash image in")
+	fs.Parse(args)
+
+	if *paymail == "" || *wif == "" {
+		return errors.New("-paymail and -key are required")
+	}
+	key, err := ec.PrivateKeyFromWif(*wif)
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+
+	var content *bitpic.Content
+	switch {
+	case len(refs) > 0 && fs.NArg() == 0:
+		content, err = bitpic.References(refs...)
+	case len(refs) == 0 && fs.NArg() == 1:
+		var image []byte
+		if image, err = readInput(fs.Arg(0)); err != nil {
+			return err
+		}
+		if *mediaType == "" {
+			*mediaType = http.DetectContentType(image)
+		}
+		if content, err = bitpic.Embed(image, *mediaType); err != nil || !*byHash {
+			break
+		}
+		hash := sha256.Sum256(image)
+		if *contentURL != "" {
+			if err = putBlob(*contentURL, image); err != nil {
+				return err
+			}
+		}
+		content, err = bitpic.Reference("c://" + hex.EncodeToString(hash[:]))
+	default:
+		return errors.New("expected either an image file or -ref")
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := bitpic.NewOutput(content, *paymail, key)
+	if err != nil {
+		return err
+	}
+	return printJSON(struct {
+		Paymail   string `json:"paymail"`
+		MediaType string `json:"mediaType"`
+		Satoshis  uint64 `json:"satoshis"`
+		Script    string `json:"script"` // locking script hex
+	}{*paymail, content.MediaType, out.Satoshis, hex.EncodeToString(out.LockingScript.Bytes())})
+}
+
+// runExport dumps the avatar index as JSON lines.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	storageURL := storageFlag(fs)
+	output := fs.String("o", "-", "output file")
+	fs.Parse(args)
+
+	store, err := storage.Open(*storageURL)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	w := os.Stdout
+	if *output != "-" {
+		if w, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	n, err := storage.Export(store, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d avatar records\n", n)
+	return nil
+}
+
+// runImport restores a dump written by export.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	storageURL := storageFlag(fs)
+	fs.Parse(args)
+
+	store, err := storage.Open(*storageURL)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	r := os.Stdin
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		if r, err = os.Open(fs.Arg(0)); err != nil {
+			return err
+		}
+		defer r.Close()
+	}
+	n, err := storage.Import(store, r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d avatar records\n", n)
+	return nil
+}
+
+// runReindex re-processes the BitPic transactions in a block range.
+func runReindex(args []string) error {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	storageURL := storageFlag(fs)
+	replayPath := fs.String("replay", os.Getenv("REPLAY_PATH"), "read blocks from a tx dump instead of JungleBus")
+	junglebusURL := fs.String("junglebus", getEnv("JUNGLEBUS_URL", "https://junglebus.gorillapool.io"), "JungleBus URL")
+	subscriptionID := fs.String("subscription", getEnv("JUNGLEBUS_SUBSCRIPTION_ID", "d40d60de8e6fdaa627eefb14ea685052f5955e278d54f19e6564d6c5e5015eb3"), "JungleBus subscription ID")
+	contentURL := fs.String("content", os.Getenv("CONTENT_STORE_URL"), "content store URL to keep embedded images in")
+	ownerCheck := fs.String("owner", getEnv("OWNER_CHECK", "off"), "paymail ownership check: off, record or require")
+	from := fs.Uint64("from", 0, "first block")
+	to := fs.Uint64("to", 0, "last block")
+	fs.Parse(args)
+
+	if *from == 0 || *to == 0 {
+		return errors.New("-from and -to are required")
+	}
+	if *to < *from {
+		return fmt.Errorf("invalid block range %d-%d", *from, *to)
+	}
+
+	store, err := storage.Open(*storageURL)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var source junglebus.ChainSource
+	if *replayPath != "" {
+		source, err = junglebus.OpenReplay(*replayPath)
+	} else {
+		source, err = junglebus.NewJungleBusSource(*junglebusURL, *subscriptionID)
+	}
+	if err != nil {
+		return err
+	}
+
+	subscriber := junglebus.NewSubscriber(source, store)
+	if *contentURL != "" {
+		blobs, err := storage.OpenBlobStore(*contentURL)
+		if err != nil {
+			return err
+		}
+		defer blobs.Close()
+		subscriber.SetContentStore(blobs)
+	}
+	owners, err := bsvalias.ForPolicy(*ownerCheck, 10*time.Second, time.Hour)
+	if err != nil {
+		return err
+	}
+	if owners != nil {
+		owners.SetLocal("bitpic.net", store)
+		subscriber.SetOwnerVerifier(owners)
+	}
+
+	cursor, err := store.GetLastBlock()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := subscriber.Reindex(ctx, *from, *to); err != nil {
+		return err
+	}
+
+	// A reorged block in the range rolls back everything above it too.
+	if after, err := store.GetLastBlock(); err == nil && after < cursor {
+		fmt.Fprintf(os.Stderr, "warning: a block in the range was reorged; avatars confirmed above block %d are unconfirmed "+
+			"and the sync cursor moved back from %d - run the indexer to re-confirm them\n", after, cursor)
+	}
+	return nil
+}
+
+// runLookup prints a paymail's current avatar and, optionally, its history.
+func runLookup(args []string) error {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	storageURL := storageFlag(fs)
+	history := fs.Bool("history", false, "include every avatar record")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected one paymail")
+	}
+
+	store, err := storage.Open(*storageURL)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return lookup(store, fs.Arg(0), *history)
+}
+
+// lookup prints paymail's current avatar in store and, with history, every
+// avatar record.
+func lookup(store storage.Store, paymail string, history bool) error {
+	current, err := store.GetAvatarData(paymail)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("no avatar for %s", paymail)
+	}
+	if !history {
+		return printJSON(current)
+	}
+
+	var records []storage.AvatarData
+	cursor := ""
+	for {
+		items, next, err := store.GetAvatarHistory(paymail, cursor, 100)
+		if err != nil {
+			return err
+		}
+		records = append(records, items...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return printJSON(struct {
+		Current *storage.AvatarData  `json:"current"`
+		History []storage.AvatarData `json:"history"`
+	}{current, records})
+}
+
+// putBlob adds data to the content store at url.
+func putBlob(url string, data []byte) error {
+	blobs, err := storage.OpenBlobStore(url)
+	if err != nil {
+		return err
+	}
+	defer blobs.Close()
+	_, err = blobs.PutBlob(data)
+	return err
+}
+
+// storageFlag adds the -storage flag, defaulting like the server does.
+func storageFlag(fs *flag.FlagSet) *string {
+	return fs.String("storage", getEnv("STORAGE_URL", getEnv("REDIS_URL", "redis://localhost:6379")),
+		"storage URL (redis://, sqlite://<path> or memory://)")
+}
+
+// readInput reads a file, or stdin for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// decodeTx reads a transaction file: hex or binary, bare or BEEF.
+func decodeTx(raw []byte) (*transaction.Transaction, error) {
+	if b, err := hex.DecodeString(strings.TrimSpace(string(raw))); err == nil {
+		raw = b
+	}
+	if tx, err := transaction.NewTransactionFromBEEF(raw); err == nil && tx != nil {
+		return tx, nil
+	}
+	tx, err := transaction.NewTransactionFromBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// stdout is where commands print their results.
+var stdout io.Writer = os.Stdout
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
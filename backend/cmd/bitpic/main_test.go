@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/bitcoin-sv/go-templates/template/bitcom"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+// captureStdout runs f with stdout redirected to a buffer
+func captureStdout(t *testing.T, f func() error) ([]byte, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	stdout = &buf
+	t.Cleanup(func() { stdout = os.Stdout })
+	err := f()
+	return buf.Bytes(), err
+}
+
+// writeTx writes tx's hex to a file and returns its path
+func writeTx(t *testing.T, tx *transaction.Transaction) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tx.hex")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(tx.Bytes())+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testKey(t *testing.T) *ec.PrivateKey {
+	t.Helper()
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestArgs(t *testing.T) {
+	t.Setenv("BITPIC_KEY", "")
+	wif := testKey(t).Wif()
+	ref := "ord://" + strings.Repeat("1", 64) + "_0"
+
+	tests := []struct {
+		name    string
+		run     func([]string) error
+		args    []string
+		wantErr string
+	}{
+		{"verify without a file", runVerify, nil, "expected one transaction file"},
+		{"verify with two files", runVerify, []string{"a", "b"}, "expected one transaction file"},
+		{"verify missing file", runVerify, []string{filepath.Join(t.TempDir(), "nope")}, "no such file"},
+		{"publish without paymail", runPublish, []string{"-key", wif, "-ref", ref}, "-paymail and -key are required"},
+		{"publish without key", runPublish, []string{"-paymail", "a@b.c", "-ref", ref}, "-paymail and -key are required"},
+		{"publish bad key", runPublish, []string{"-paymail", "a@b.c", "-key", "nope", "-ref", ref}, "invalid key"},
+		{"publish image and ref", runPublish, []string{"-paymail", "a@b.c", "-key", wif, "-ref", ref, "img.png"}, "expected either an image file or -ref"},
+		{"publish nothing", runPublish, []string{"-paymail", "a@b.c", "-key", wif}, "expected either an image file or -ref"},
+		{"publish bad ref", runPublish, []string{"-paymail", "a@b.c", "-key", wif, "-ref", "https://example.com"}, "invalid BitPic reference"},
+		{"reindex without range", runReindex, []string{"-from", "1"}, "-from and -to are required"},
+		{"reindex backwards range", runReindex, []string{"-from", "10", "-to", "9"}, "invalid block range 10-9"},
+		{"lookup without paymail", runLookup, []string{"-storage", "memory://"}, "expected one paymail"},
+		{"lookup unknown paymail", runLookup, []string{"-storage", "memory://", "a@b.c"}, "no avatar for a@b.c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := captureStdout(t, func() error { return tt.run(tt.args) })
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := testKey(t)
+	content, err := bitpic.Embed([]byte("\x89PNG\r\n\x1a\nimage"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := bitpic.NewOutput(content, "alice@example.com", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTransaction()
+	tx.AddOutput(out)
+
+	data, err := captureStdout(t, func() error { return runVerify([]string{writeTx(t, tx)}) })
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	var outputs []struct {
+		Outpoint string             `json:"outpoint"`
+		Data     *bitpic.BitPicData `json:"data"`
+		Reason   string             `json:"reason"`
+	}
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		t.Fatalf("bad output %q: %v", data, err)
+	}
+	if len(outputs) != 1 || outputs[0].Data == nil || outputs[0].Reason != "" {
+		t.Fatalf("outputs = %s", data)
+	}
+	if got := outputs[0].Data; got.Paymail != "alice@example.com" || got.PubKey != hex.EncodeToString(key.PubKey().Compressed()) ||
+		got.Outpoint != tx.TxID().String()+"_0" || got.Image != nil {
+		t.Errorf("record = %+v", got)
+	}
+
+	// A BitPic output whose signature doesn't match is listed with its reason
+	other, _ := bitpic.Embed([]byte("\x89PNG\r\n\x1a\nother"), "image/png")
+	tx.Outputs[0].LockingScript = badSigScript(t, content, other, "alice@example.com", key)
+	data, err = captureStdout(t, func() error { return runVerify([]string{writeTx(t, tx)}) })
+	if err == nil || !strings.Contains(string(data), `"reason": "bad_signature"`) {
+		t.Errorf("tampered tx: error = %v, output = %s", err, data)
+	}
+}
+
+// badSigScript returns a BitPic script publishing content with a signature
+// made over other content
+func badSigScript(t *testing.T, content, other *bitpic.Content, paymail string, key *ec.PrivateKey) *script.Script {
+	t.Helper()
+	pubKey, sig, err := bitpic.Sign(other, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &script.Script{}
+	_ = s.AppendOpcodes(script.OpFALSE, script.OpRETURN)
+	for _, push := range []string{bitcom.BPrefix, string(content.Data), content.MediaType, content.Encoding, "|", bitpic.BitPicPrefix, paymail, pubKey, sig} {
+		if err := s.AppendPushData([]byte(push)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestPublishRef(t *testing.T) {
+	key := testKey(t)
+	refs := []string{"ord://" + strings.Repeat("1", 64) + "_0", "b://" + strings.Repeat("2", 64) + "_1"}
+
+	data, err := captureStdout(t, func() error {
+		return runPublish([]string{"-paymail", "alice@example.com", "-key", key.Wif(), "-ref", refs[0], "-ref", refs[1]})
+	})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	var out struct {
+		Paymail   string `json:"paymail"`
+		MediaType string `json:"mediaType"`
+		Script    string `json:"script"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("bad output %q: %v", data, err)
+	}
+	s, err := script.NewFromHex(out.Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTransaction()
+	tx.AddOutput(&transaction.TransactionOutput{LockingScript: s})
+
+	parsed, err := bitpic.ParseTransaction(tx.Bytes())
+	if err != nil {
+		t.Fatalf("published script doesn't parse: %v", err)
+	}
+	if parsed.Paymail != "alice@example.com" || out.MediaType != bitpic.UriListMime || !reflect.DeepEqual(parsed.RefURIs, refs) {
+		t.Errorf("parsed = %+v, mediaType %q", parsed, out.MediaType)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	store := storage.NewMemoryStore()
+	older := storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("a", 64), Timestamp: 100}
+	newer := storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("b", 64), Timestamp: 200}
+	older.Outpoint, newer.Outpoint = older.TxID+"_0", newer.TxID+"_0"
+	for _, rec := range []storage.AvatarData{older, newer} {
+		if err := store.SetAvatar(&rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := captureStdout(t, func() error { return lookup(store, "alice@example.com", false) })
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	var current storage.AvatarData
+	if err := json.Unmarshal(data, &current); err != nil || current.TxID != newer.TxID {
+		t.Errorf("current = %s (%v), want %s", data, err, newer.TxID)
+	}
+
+	data, err = captureStdout(t, func() error { return lookup(store, "alice@example.com", true) })
+	if err != nil {
+		t.Fatalf("lookup -history: %v", err)
+	}
+	var withHistory struct {
+		Current *storage.AvatarData  `json:"current"`
+		History []storage.AvatarData `json:"history"`
+	}
+	if err := json.Unmarshal(data, &withHistory); err != nil {
+		t.Fatalf("bad output %q: %v", data, err)
+	}
+	if withHistory.Current == nil || withHistory.Current.TxID != newer.TxID || len(withHistory.History) != 2 {
+		t.Errorf("lookup -history = %s", data)
+	}
+
+	if err := lookup(store, "bob@example.com", false); err == nil || !strings.Contains(err.Error(), "no avatar") {
+		t.Errorf("unknown paymail: error = %v", err)
+	}
+}
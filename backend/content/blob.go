@@ -0,0 +1,39 @@
+package content
+
+import (
+	"context"
+
+	"github.com/b-open-io/bitpic/storage"
+)
+
+// Blobs resolves content by hash from a local content store.
+type Blobs struct {
+	store storage.BlobStore
+}
+
+var _ Resolver = (*Blobs)(nil)
+
+// NewBlobs creates a resolver over a content store
+func NewBlobs(store storage.BlobStore) *Blobs {
+	return &Blobs{store: store}
+}
+
+// Name identifies the resolver in logs
+func (b *Blobs) Name() string {
+	return "content-store"
+}
+
+// Resolve returns the blob for ref.Hash
+func (b *Blobs) Resolve(_ context.Context, ref Ref) ([]byte, error) {
+	if ref.Hash == "" {
+		return nil, ErrNotFound
+	}
+	data, err := b.store.GetBlob(ref.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
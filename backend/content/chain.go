@@ -0,0 +1,164 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// A resolver failing this many times in a row is marked unhealthy...
+	failThreshold = 3
+	// ...and only tried, for this long, once every healthy one has failed.
+	unhealthyCooldown = 30 * time.Second
+)
+
+// Chain tries its resolvers in order until one returns content (matching
+// ref.Hash, when set). Resolvers that error repeatedly are demoted behind the
+// healthy ones for a cooldown; not-found answers don't count against them.
+type Chain struct {
+	members    []*member
+	onMismatch func(resolver string, ref Ref)
+}
+
+type member struct {
+	resolver Resolver
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+var _ Resolver = (*Chain)(nil)
+
+// ResolverHealth is a chain member's current state
+type ResolverHealth struct {
+	Name     string `json:"name"`
+	Healthy  bool   `json:"healthy"`
+	Failures int    `json:"failures"` // consecutive
+}
+
+// NewChain creates a resolver trying resolvers in the given order
+func NewChain(resolvers ...Resolver) *Chain {
+	c := &Chain{}
+	for _, r := range resolvers {
+		c.members = append(c.members, &member{resolver: r})
+	}
+	return c
+}
+
+// OnHashMismatch sets a function called whenever a resolver returns content
+// that fails its hash check. Call before use.
+func (c *Chain) OnHashMismatch(fn func(resolver string, ref Ref)) {
+	c.onMismatch = fn
+}
+
+// Name identifies the resolver in logs
+func (c *Chain) Name() string {
+	return "chain"
+}
+
+// Resolve returns the content from the first resolver that has it. If none
+// does, the error is ErrHashMismatch if any returned the wrong bytes, else the
+// first resolver error, else ErrNotFound.
+func (c *Chain) Resolve(ctx context.Context, ref Ref) ([]byte, error) {
+	var firstErr error
+	mismatch := false
+
+	for _, m := range c.order() {
+		data, err := m.resolver.Resolve(ctx, ref)
+		switch {
+		case err == nil && Matches(data, ref.Hash):
+			m.succeeded()
+			return data, nil
+
+		case err == nil:
+			m.failed()
+			mismatch = true
+			log.Printf("Content hash mismatch: %s returned bad bytes for %s (expected %s)", m.resolver.Name(), ref, ref.Hash)
+			if c.onMismatch != nil {
+				c.onMismatch(m.resolver.Name(), ref)
+			}
+
+		case errors.Is(err, ErrNotFound):
+			m.succeeded()
+
+		case errors.Is(err, ErrTooLarge):
+			return nil, ErrTooLarge
+
+		default:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			m.failed()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", m.resolver.Name(), err)
+			}
+		}
+	}
+
+	switch {
+	case mismatch:
+		return nil, ErrHashMismatch
+	case firstErr != nil:
+		return nil, firstErr
+	default:
+		return nil, ErrNotFound
+	}
+}
+
+// order returns the healthy members, then the unhealthy ones as a last resort
+func (c *Chain) order() []*member {
+	now := time.Now()
+	healthy := make([]*member, 0, len(c.members))
+	var down []*member
+	for _, m := range c.members {
+		if m.healthy(now) {
+			healthy = append(healthy, m)
+		} else {
+			down = append(down, m)
+		}
+	}
+	return append(healthy, down...)
+}
+
+// Health reports each member's state, in chain order
+func (c *Chain) Health() []ResolverHealth {
+	now := time.Now()
+	out := make([]ResolverHealth, 0, len(c.members))
+	for _, m := range c.members {
+		m.mu.Lock()
+		failures := m.failures
+		m.mu.Unlock()
+		out = append(out, ResolverHealth{Name: m.resolver.Name(), Healthy: m.healthy(now), Failures: failures})
+	}
+	return out
+}
+
+func (m *member) healthy(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !now.Before(m.downUntil)
+}
+
+func (m *member) succeeded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = 0
+	m.downUntil = time.Time{}
+}
+
+func (m *member) failed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures++
+	if m.failures >= failThreshold {
+		m.downUntil = time.Now().Add(unhealthyCooldown)
+		if m.failures == failThreshold {
+			log.Printf("Content resolver %s marked unhealthy after %d failures", m.resolver.Name(), m.failures)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	image     = []byte("\x89PNG\r\n\x1a\navatar")
+	imageHash = func() string {
+		sum := sha256.Sum256(image)
+		return hex.EncodeToString(sum[:])
+	}()
+	imageRef = Ref{Outpoint: "abc_0", Hash: imageHash}
+)
+
+// server serves body with status on every path, counting hits
+func server(t *testing.T, status int, body []byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestChainFailsOverToNextResolver(t *testing.T) {
+	down, _ := server(t, http.StatusBadGateway, nil)
+	up, _ := server(t, http.StatusOK, image)
+	chain := NewChain(NewORDFS(down.URL, time.Second, DefaultMaxBytes), NewORDFS(up.URL, time.Second, DefaultMaxBytes))
+
+	data, err := chain.Resolve(context.Background(), imageRef)
+	if err != nil || string(data) != string(image) {
+		t.Fatalf("Resolve = %q, %v; want the second server's image", data, err)
+	}
+	if health := chain.Health(); health[0].Failures != 1 || !health[0].Healthy || health[1].Failures != 0 {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestChainSkipsHashMismatch(t *testing.T) {
+	bad, _ := server(t, http.StatusOK, []byte("not the image"))
+	good, _ := server(t, http.StatusOK, image)
+	chain := NewChain(NewHashURL(bad.URL+"/sha256/"+HashPlaceholder, time.Second, DefaultMaxBytes), NewORDFS(good.URL, time.Second, DefaultMaxBytes))
+
+	var mismatches []string
+	chain.OnHashMismatch(func(resolver string, ref Ref) { mismatches = append(mismatches, resolver) })
+
+	data, err := chain.Resolve(context.Background(), imageRef)
+	if err != nil || string(data) != string(image) {
+		t.Fatalf("Resolve = %q, %v; want the matching bytes", data, err)
+	}
+	if len(mismatches) != 1 || mismatches[0] != bad.URL+"/sha256/"+HashPlaceholder {
+		t.Errorf("mismatches = %v, want the hash-url resolver", mismatches)
+	}
+
+	// With no good resolver left, the mismatch is the error
+	chain = NewChain(NewORDFS(bad.URL, time.Second, DefaultMaxBytes))
+	if _, err := chain.Resolve(context.Background(), imageRef); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Resolve error = %v, want ErrHashMismatch", err)
+	}
+}
+
+func TestChainDemotesUnhealthyResolver(t *testing.T) {
+	down, downHits := server(t, http.StatusInternalServerError, nil)
+	up, upHits := server(t, http.StatusOK, image)
+	chain := NewChain(NewORDFS(down.URL, time.Second, DefaultMaxBytes), NewORDFS(up.URL, time.Second, DefaultMaxBytes))
+
+	for i := 0; i < failThreshold+2; i++ {
+		if _, err := chain.Resolve(context.Background(), imageRef); err != nil {
+			t.Fatalf("Resolve %d: %v", i, err)
+		}
+	}
+	if got := downHits.Load(); got != failThreshold {
+		t.Errorf("failing server hit %d times, want %d before it is demoted", got, failThreshold)
+	}
+	if got := upHits.Load(); got != failThreshold+2 {
+		t.Errorf("healthy server hit %d times, want %d", got, failThreshold+2)
+	}
+	if health := chain.Health(); health[0].Healthy || health[0].Failures != failThreshold || !health[1].Healthy {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	missing, _ := server(t, http.StatusNotFound, nil)
+	huge, _ := server(t, http.StatusOK, make([]byte, 64))
+	ctx := context.Background()
+
+	chain := NewChain(NewORDFS(missing.URL, time.Second, DefaultMaxBytes))
+	if _, err := chain.Resolve(ctx, imageRef); !errors.Is(err, ErrNotFound) {
+		t.Errorf("all not found: error = %v, want ErrNotFound", err)
+	}
+	if health := chain.Health(); health[0].Failures != 0 {
+		t.Errorf("not found counted as a failure: %+v", health)
+	}
+
+	chain = NewChain(NewORDFS(huge.URL, time.Second, 16), NewORDFS(missing.URL, time.Second, DefaultMaxBytes))
+	if _, err := chain.Resolve(ctx, imageRef); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized: error = %v, want ErrTooLarge", err)
+	}
+
+	// A hash-only ref can't be looked up by outpoint
+	chain = NewChain(NewORDFS(missing.URL, time.Second, DefaultMaxBytes))
+	if _, err := chain.Resolve(ctx, Ref{Hash: imageHash}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("hash-only ref: error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,45 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HashPlaceholder is replaced with the SHA-256 in a HashURL template.
+const HashPlaceholder = "{hash}"
+
+// HashURL resolves content by hash from a content-addressed HTTP store, such
+// as another BitPic server's content store or a CDN in front of one.
+type HashURL struct {
+	template string
+	client   *http.Client
+	maxBytes int64
+}
+
+var _ Resolver = (*HashURL)(nil)
+
+// NewHashURL creates a resolver fetching template with HashPlaceholder
+// replaced by the hash, e.g. https://cdn.example.com/sha256/{hash}. Each
+// request is bounded by timeout and responses by maxBytes.
+func NewHashURL(template string, timeout time.Duration, maxBytes int64) *HashURL {
+	return &HashURL{
+		template: template,
+		client:   &http.Client{Timeout: timeout},
+		maxBytes: maxBytes,
+	}
+}
+
+// Name returns the URL template
+func (h *HashURL) Name() string {
+	return h.template
+}
+
+// Resolve fetches the content for ref.Hash
+func (h *HashURL) Resolve(ctx context.Context, ref Ref) ([]byte, error) {
+	if ref.Hash == "" {
+		return nil, ErrNotFound
+	}
+	return Fetch(ctx, h.client, strings.ReplaceAll(h.template, HashPlaceholder, strings.ToLower(ref.Hash)), h.maxBytes)
+}
@@ -0,0 +1,42 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ORDFS resolves outpoints through an ORDFS server's /content endpoint.
+type ORDFS struct {
+	baseURL  string
+	client   *http.Client
+	maxBytes int64
+}
+
+var _ Resolver = (*ORDFS)(nil)
+
+// NewORDFS creates a resolver for the ORDFS server at baseURL. Each request
+// is bounded by timeout and responses by maxBytes.
+func NewORDFS(baseURL string, timeout time.Duration, maxBytes int64) *ORDFS {
+	return &ORDFS{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		client:   &http.Client{Timeout: timeout},
+		maxBytes: maxBytes,
+	}
+}
+
+// Name returns the server URL
+func (o *ORDFS) Name() string {
+	return o.baseURL
+}
+
+// Resolve fetches the content at ref.Outpoint
+func (o *ORDFS) Resolve(ctx context.Context, ref Ref) ([]byte, error) {
+	if ref.Outpoint == "" {
+		return nil, ErrNotFound
+	}
+
+	return Fetch(ctx, o.client, fmt.Sprintf("%s/content/%s", o.baseURL, ref.Outpoint), o.maxBytes)
+}
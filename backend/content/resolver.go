@@ -0,0 +1,98 @@
+// Package content fetches avatar image bytes. A Resolver looks content up by
+// outpoint and/or hash; Chain tries several in turn, verifying hashes and
+// skipping resolvers that keep failing, so one degraded content host doesn't
+// take avatars down with it.
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBytes caps the size of content fetched as an avatar. Prevents a
+// huge referenced ordinal from exhausting memory/bandwidth or poisoning the
+// image cache.
+const DefaultMaxBytes = 10 << 20 // 10 MiB
+
+var (
+	// ErrNotFound: the resolver doesn't have the content.
+	ErrNotFound = errors.New("content not found")
+	// ErrTooLarge: the content exceeds the size cap.
+	ErrTooLarge = errors.New("content too large")
+	// ErrHashMismatch: the content doesn't hash to the expected SHA-256.
+	ErrHashMismatch = errors.New("content hash mismatch")
+)
+
+// Ref identifies avatar content. Outpoint is where it was inscribed or
+// published; Hash, if known, is the SHA-256 (hex) the bytes must have.
+type Ref struct {
+	Outpoint string
+	Hash     string
+}
+
+func (r Ref) String() string {
+	if r.Outpoint == "" {
+		return "sha256:" + r.Hash
+	}
+	return r.Outpoint
+}
+
+// Resolver fetches the bytes for a Ref. Resolvers that can't look a Ref up
+// (a hash-only store asked for an outpoint, say) return ErrNotFound.
+type Resolver interface {
+	Name() string
+	Resolve(ctx context.Context, ref Ref) ([]byte, error)
+}
+
+// Matches reports whether data hashes to hash. An empty hash matches anything.
+func Matches(data []byte, hash string) bool {
+	if hash == "" {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == strings.ToLower(hash)
+}
+
+// readCapped reads r, failing with ErrTooLarge past maxBytes
+func readCapped(r io.Reader, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// Fetch GETs url, mapping 404 to ErrNotFound and reading at most maxBytes
+func Fetch(ctx context.Context, client *http.Client, url string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch content: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("content server returned %s", resp.Status)
+	}
+
+	// Reject oversized content up front when the length is advertised.
+	if resp.ContentLength > maxBytes {
+		return nil, ErrTooLarge
+	}
+	return readCapped(resp.Body, maxBytes)
+}
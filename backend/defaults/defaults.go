@@ -0,0 +1,147 @@
+// Package defaults draws the built-in default avatars served for paymails
+// without one (d=identicon and friends, as on Gravatar). Each is derived
+// from a seed, the paymail, so the same paymail always gets the same image.
+package defaults
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Styles, by d= value
+const (
+	Identicon = "identicon" // symmetric 5x5 pattern
+	Initials  = "initials"  // the paymail's initials on a colored disc
+	Retro     = "retro"     // symmetric 8x8 pixel art in three colors
+	MonsterID = "monsterid" // a little monster
+	Blank     = "blank"     // transparent
+)
+
+// IsStyle reports whether d names a built-in default.
+func IsStyle(d string) bool {
+	switch d {
+	case Identicon, Initials, Retro, MonsterID, Blank:
+		return true
+	}
+	return false
+}
+
+// Generate draws style for seed at size x size.
+func Generate(style, seed string, size int) (image.Image, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid size: %d", size)
+	}
+	seed = strings.ToLower(strings.TrimSpace(seed))
+	hash := sha256.Sum256([]byte(seed))
+
+	switch style {
+	case Identicon:
+		return identicon(hash, size), nil
+	case Initials:
+		return initials(seed, hash, size)
+	case Retro:
+		return retro(hash, size), nil
+	case MonsterID:
+		return monster(hash, size), nil
+	case Blank:
+		return image.NewNRGBA(image.Rect(0, 0, size, size)), nil
+	}
+	return nil, fmt.Errorf("unknown default style %q", style)
+}
+
+// identicon draws a 5x5 grid mirrored left to right, GitHub style: one
+// color from the hash on a light background, with a margin.
+func identicon(hash [32]byte, size int) image.Image {
+	fg := hsl(hueOf(hash), 0.55, 0.55)
+	bg := color.NRGBA{0xF0, 0xF0, 0xF0, 0xFF}
+
+	var cells [5][5]bool
+	for i := 0; i < 15; i++ {
+		row, col := i/3, i%3
+		on := hash[2+i]&1 == 1
+		cells[row][col], cells[row][4-col] = on, on
+	}
+
+	margin := float64(size) / 12
+	cell := (float64(size) - 2*margin) / 5
+	return paint(size, func(x, y float64) color.NRGBA {
+		col, row := int((x-margin)/cell), int((y-margin)/cell)
+		if x < margin || y < margin || col > 4 || row > 4 || !cells[row][col] {
+			return bg
+		}
+		return fg
+	})
+}
+
+// retro draws an 8x8 grid mirrored left to right, each cell background or
+// one of two colors.
+func retro(hash [32]byte, size int) image.Image {
+	hue := hueOf(hash)
+	colors := [4]color.NRGBA{
+		{0xFF, 0xFF, 0xFF, 0xFF},
+		hsl(hue, 0.7, 0.45),
+		hsl(math.Mod(hue+0.5, 1), 0.6, 0.35),
+		hsl(hue, 0.7, 0.45),
+	}
+
+	var cells [8][8]uint8
+	for i := 0; i < 32; i++ {
+		row, col := i/4, i%4
+		v := hash[i] >> 6 // two bits, weighted towards the first color
+		cells[row][col], cells[row][7-col] = v, v
+	}
+
+	cell := float64(size) / 8
+	return paint(size, func(x, y float64) color.NRGBA {
+		return colors[cells[min(int(y/cell), 7)][min(int(x/cell), 7)]]
+	})
+}
+
+// paint fills a size x size image from f, sampled at each pixel's center
+func paint(size int, f func(x, y float64) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetNRGBA(x, y, f(float64(x)+0.5, float64(y)+0.5))
+		}
+	}
+	return img
+}
+
+// hueOf returns a hue in [0, 1) from the hash
+func hueOf(hash [32]byte) float64 {
+	return float64(uint16(hash[0])<<8|uint16(hash[1])) / 65536
+}
+
+// hsl converts a hue, saturation and lightness, each in [0, 1], to a color
+func hsl(h, s, l float64) color.NRGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := h * 6
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch int(hp) % 6 {
+	case 0:
+		r, g = c, x
+	case 1:
+		r, g = x, c
+	case 2:
+		g, b = c, x
+	case 3:
+		g, b = x, c
+	case 4:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.NRGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 0xFF,
+	}
+}
@@ -0,0 +1,83 @@
+package defaults
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var boldFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gobold.TTF)
+})
+
+// initials draws up to two initials of the paymail's alias, white on a disc
+// colored from the hash.
+func initials(seed string, hash [32]byte, size int) (image.Image, error) {
+	bg := hsl(hueOf(hash), 0.5, 0.45)
+	r := float64(size) / 2
+	img := paint(size, func(x, y float64) color.NRGBA {
+		if (x-r)*(x-r)+(y-r)*(y-r) > r*r {
+			return color.NRGBA{}
+		}
+		return bg
+	})
+
+	text := initialsOf(seed)
+	if text == "" {
+		return img, nil
+	}
+
+	f, err := boldFont()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(size) * 0.4,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	defer face.Close()
+
+	// Center the text's ink, not its advance box
+	bounds, _ := font.BoundString(face, text)
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+	half := fixed.I(size) / 2
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.White),
+		Face: face,
+		Dot:  fixed.Point26_6{X: half - width/2 - bounds.Min.X, Y: half - height/2 - bounds.Min.Y},
+	}
+	d.DrawString(text)
+	return img, nil
+}
+
+// initialsOf returns the first letter or digit of the first two words of a
+// paymail's alias ("alice.smith@…" is "AS"), upper-cased.
+func initialsOf(paymail string) string {
+	alias, _, _ := strings.Cut(paymail, "@")
+	words := strings.FieldsFunc(alias, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var out []rune
+	for _, word := range words {
+		if len(out) == 2 {
+			break
+		}
+		out = append(out, unicode.ToUpper([]rune(word)[0]))
+	}
+	return string(out)
+}
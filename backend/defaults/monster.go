@@ -0,0 +1,107 @@
+package defaults
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// monster draws a little monster: a colored body with horns or antennae,
+// one or two eyes looking somewhere and a mouth, maybe with teeth. Shapes are
+// laid out in unit coordinates so it looks the same at any size.
+func monster(hash [32]byte, size int) image.Image {
+	hue := hueOf(hash)
+	bg := hsl(math.Mod(hue+0.5, 1), 0.35, 0.92)
+	body := hsl(hue, 0.6, 0.5)
+	dark := hsl(hue, 0.6, 0.25)
+	white := color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	black := color.NRGBA{0x20, 0x20, 0x20, 0xFF}
+
+	unit := func(b byte, lo, hi float64) float64 { return lo + (hi-lo)*float64(b)/255 }
+
+	// Body: an ellipse in the lower part
+	bx, by := 0.5, 0.6
+	rx, ry := unit(hash[2], 0.28, 0.4), unit(hash[3], 0.26, 0.34)
+
+	// Horns (triangles) or antennae (stalks with a ball)
+	horns := hash[4]&1 == 1
+	spread := unit(hash[5], 0.12, 0.22)
+	tipY := unit(hash[6], 0.08, 0.16)
+
+	// One big eye or two
+	cyclops := hash[7]%4 == 0
+	eyeR := unit(hash[8], 0.07, 0.1)
+	if cyclops {
+		eyeR *= 1.6
+	}
+	eyeY := by - ry*0.25
+	eyeDX := unit(hash[9], 0.1, 0.15)
+	lookX, lookY := unit(hash[10], -0.4, 0.4)*eyeR, unit(hash[11], -0.4, 0.4)*eyeR
+
+	// Mouth: a slot, with teeth across the top edge
+	mouthW := unit(hash[12], 0.1, 0.2)
+	mouthY := by + ry*0.45
+	mouthH := unit(hash[13], 0.04, 0.08)
+	teeth := int(hash[14]%4) * 2 // 0, 2, 4 or 6
+
+	inEllipse := func(x, y, cx, cy, rx, ry float64) bool {
+		dx, dy := (x-cx)/rx, (y-cy)/ry
+		return dx*dx+dy*dy <= 1
+	}
+	inCircle := func(x, y, cx, cy, r float64) bool { return inEllipse(x, y, cx, cy, r, r) }
+
+	eyes := []float64{bx - eyeDX, bx + eyeDX}
+	if cyclops {
+		eyes = []float64{bx}
+	}
+
+	s := float64(size)
+	return paint(size, func(px, py float64) color.NRGBA {
+		x, y := px/s, py/s
+
+		for _, ex := range eyes {
+			if inCircle(x, y, ex+lookX, eyeY+lookY, eyeR*0.45) {
+				return black
+			}
+			if inCircle(x, y, ex, eyeY, eyeR) {
+				return white
+			}
+		}
+
+		if math.Abs(x-bx) <= mouthW && y >= mouthY && y <= mouthY+mouthH {
+			if teeth > 0 && y <= mouthY+mouthH*0.45 {
+				// alternating teeth and gaps across the mouth
+				if int((x-bx+mouthW)/(2*mouthW)*float64(2*teeth-1))%2 == 0 {
+					return white
+				}
+			}
+			return dark
+		}
+
+		if inEllipse(x, y, bx, by, rx, ry) {
+			return body
+		}
+
+		top := by - ry
+		for _, side := range []float64{-1, 1} {
+			hx := bx + side*spread
+			if horns {
+				// a triangle from the body's top up to the tip
+				if y >= tipY && y <= top+0.05 {
+					half := 0.06 * (y - tipY) / (top + 0.05 - tipY)
+					if math.Abs(x-hx) <= half {
+						return dark
+					}
+				}
+			} else {
+				if inCircle(x, y, hx, tipY, 0.04) {
+					return dark
+				}
+				if math.Abs(x-hx) <= 0.012 && y >= tipY && y <= top+0.05 {
+					return dark
+				}
+			}
+		}
+		return bg
+	})
+}
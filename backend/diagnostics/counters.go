@@ -0,0 +1,27 @@
+package diagnostics
+
+import "sync/atomic"
+
+// Counters are running totals, since startup, of events an operator should
+// notice. They are safe for concurrent use.
+type Counters struct {
+	contentHashMismatches atomic.Uint64
+}
+
+// CounterSnapshot is a point-in-time copy of Counters
+type CounterSnapshot struct {
+	// Fetched avatar content whose SHA-256 didn't match the signed hash.
+	ContentHashMismatches uint64 `json:"contentHashMismatches"`
+}
+
+// ContentHashMismatch counts fetched content refused for failing its hash check
+func (c *Counters) ContentHashMismatch() {
+	c.contentHashMismatches.Add(1)
+}
+
+// Snapshot returns the current totals
+func (c *Counters) Snapshot() CounterSnapshot {
+	return CounterSnapshot{
+		ContentHashMismatches: c.contentHashMismatches.Load(),
+	}
+}
@@ -0,0 +1,85 @@
+// Package diagnostics keeps in-memory records that help explain indexer
+// behaviour to users, such as why an avatar update was not picked up.
+package diagnostics
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reject is a transaction that carried a BitPic tape but failed validation.
+type Reject struct {
+	TxID        string `json:"txid"`
+	Paymail     string `json:"paymail,omitempty"`
+	Reason      string `json:"reason"` // bitpic.Reason code
+	Detail      string `json:"detail"`
+	BlockHeight uint64 `json:"blockHeight,omitempty"` // 0 for mempool
+	Timestamp   int64  `json:"timestamp"`             // when it was rejected
+	Source      string `json:"source"`                // SourceIndexer or SourceBroadcast
+}
+
+// Where a reject was seen
+const (
+	SourceIndexer   = "indexer"   // the chain subscriber
+	SourceBroadcast = "broadcast" // POST /api/broadcast
+)
+
+// RejectLog is a fixed-size ring of the most recent rejects. It is safe for
+// concurrent use.
+type RejectLog struct {
+	mu      sync.Mutex
+	entries []Reject
+	next    int
+	full    bool
+}
+
+// NewRejectLog creates a log holding up to size rejects
+func NewRejectLog(size int) *RejectLog {
+	if size < 1 {
+		size = 1
+	}
+	return &RejectLog{entries: make([]Reject, size)}
+}
+
+// Add records a reject, evicting the oldest once the log is full. A zero
+// Timestamp is set to now.
+func (l *RejectLog) Add(r Reject) {
+	if r.Timestamp == 0 {
+		r.Timestamp = time.Now().Unix()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = r
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns up to limit rejects, newest first, optionally only those for
+// paymail (case-insensitive). limit <= 0 returns all of them.
+func (l *RejectLog) Recent(paymail string, limit int) []Reject {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+
+	out := []Reject{}
+	for i := 1; i <= n; i++ {
+		r := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if paymail != "" && !strings.EqualFold(r.Paymail, paymail) {
+			continue
+		}
+		out = append(out, r)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
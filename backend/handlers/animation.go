@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/webp"
+)
+
+// maxAnimationPixels caps frames x canvas pixels of an animation we resize.
+// Every frame is decoded and scaled, so without it a hostile GIF could keep
+// a request busy for minutes; larger animations are served unresized. It is
+// checked from the headers alone, before any frame is decoded.
+const maxAnimationPixels = 32 << 20
+
+var (
+	errAnimationTooLarge = errors.New("animation too large to resize")
+	errInvalidAnimation  = errors.New("invalid animation")
+)
+
+// resizeAnimation resizes an animated GIF or WebP to size (square, with
+// crop) frame by frame, keeping its format, frame delays, disposal and loop
+// count. Size 0 returns it as is. ok is false if data isn't an animation.
+func resizeAnimation(data []byte, size int) (resized []byte, contentType string, ok bool, err error) {
+	switch contentType = detectContentType(data); contentType {
+	case "image/gif":
+		frames, area, err := scanGIF(data)
+		if err != nil || frames < 2 {
+			return nil, "", false, nil
+		}
+		if size == 0 {
+			return data, contentType, true, nil
+		}
+		if int64(frames)*area > maxAnimationPixels {
+			return nil, "", true, fmt.Errorf("%w: %d frames", errAnimationTooLarge, frames)
+		}
+		resized, err = resizeGIF(data, size)
+		return resized, contentType, true, err
+
+	case "image/webp":
+		anim, err := parseAnimatedWebP(data)
+		if err != nil {
+			return nil, "", true, err
+		}
+		if anim == nil {
+			return nil, "", false, nil
+		}
+		if size == 0 {
+			return data, contentType, true, nil
+		}
+		if int64(len(anim.frames))*int64(anim.width)*int64(anim.height) > maxAnimationPixels {
+			return nil, "", true, fmt.Errorf("%w: %d frames", errAnimationTooLarge, len(anim.frames))
+		}
+		resized, err = anim.resize(size)
+		return resized, contentType, true, err
+	}
+	return nil, "", false, nil
+}
+
+// scanGIF counts the frames of a GIF and returns its canvas area, without
+// decompressing any of them
+func scanGIF(data []byte) (frames int, area int64, err error) {
+	if len(data) < 13 {
+		return 0, 0, errInvalidAnimation
+	}
+	area = int64(binary.LittleEndian.Uint16(data[6:8])) * int64(binary.LittleEndian.Uint16(data[8:10]))
+
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&7 + 1) // global color table
+	}
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label, then data sub-blocks
+			pos = skipSubBlocks(data, pos+2)
+		case 0x2C: // image: descriptor, local color table, LZW code size, data sub-blocks
+			if pos+10 > len(data) {
+				return frames, area, errInvalidAnimation
+			}
+			packed := data[pos+9]
+			pos += 10
+			if packed&0x80 != 0 {
+				pos += 3 << (packed&7 + 1)
+			}
+			pos = skipSubBlocks(data, pos+1)
+			frames++
+		case 0x3B: // trailer
+			return frames, area, nil
+		default:
+			return frames, area, errInvalidAnimation
+		}
+	}
+	return frames, area, nil
+}
+
+func skipSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		n := int(data[pos])
+		pos++
+		if n == 0 {
+			return pos
+		}
+		pos += n
+	}
+	return len(data)
+}
+
+// resizeGIF resizes every frame of an animated GIF on its own, in its own
+// palette, so frame bounds, delays and disposal carry over.
+func resizeGIF(data []byte, size int) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode GIF: %w", err)
+	}
+
+	crop := cropSquare(g.Config.Width, g.Config.Height)
+	out := &gif.GIF{
+		LoopCount:       g.LoopCount,
+		BackgroundIndex: g.BackgroundIndex,
+		Config:          image.Config{ColorModel: g.Config.ColorModel, Width: size, Height: size},
+	}
+	skipped := 0 // delay of leading frames outside the crop
+	for i, frame := range g.Image {
+		r := frame.Bounds().Intersect(crop)
+		if r.Empty() {
+			// Nothing of the frame shows: its time goes to the previous one
+			if n := len(out.Delay); n > 0 {
+				out.Delay[n-1] += g.Delay[i]
+			} else {
+				skipped += g.Delay[i]
+			}
+			continue
+		}
+
+		dr := scaleRect(r, crop, size)
+		scaled := image.NewNRGBA(dr)
+		draw.CatmullRom.Scale(scaled, dr, frame, r, draw.Src, nil)
+
+		out.Image = append(out.Image, toPalette(scaled, frame.Palette))
+		out.Delay = append(out.Delay, g.Delay[i]+skipped)
+		out.Disposal = append(out.Disposal, g.Disposal[i])
+		skipped = 0
+	}
+	if len(out.Image) == 0 {
+		return nil, fmt.Errorf("%w: no frame inside the crop", errInvalidAnimation)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, out); err != nil {
+		return nil, fmt.Errorf("failed to encode GIF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleRect maps r, part of crop, onto the size x size output, rounding
+// outwards so neighbouring frames leave no gaps
+func scaleRect(r, crop image.Rectangle, size int) image.Rectangle {
+	n := crop.Dx()
+	scaled := image.Rect(
+		(r.Min.X-crop.Min.X)*size/n,
+		(r.Min.Y-crop.Min.Y)*size/n,
+		((r.Max.X-crop.Min.X)*size+n-1)/n,
+		((r.Max.Y-crop.Min.Y)*size+n-1)/n,
+	)
+	return scaled.Intersect(image.Rect(0, 0, size, size))
+}
+
+// toPalette maps img onto p: pixels less than half opaque become p's
+// transparent color if it has one, the rest the nearest opaque color.
+func toPalette(img *image.NRGBA, p color.Palette) *image.Paletted {
+	transparent := -1
+	for i, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent = i
+			break
+		}
+	}
+
+	dst := image.NewPaletted(img.Rect, p)
+	nearest := make(map[color.NRGBA]uint8)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 0x80 && transparent >= 0 {
+				dst.SetColorIndex(x, y, uint8(transparent))
+				continue
+			}
+			c.A = 0xFF
+			idx, ok := nearest[c]
+			if !ok {
+				idx = uint8(p.Index(c))
+				nearest[c] = idx
+			}
+			dst.SetColorIndex(x, y, idx)
+		}
+	}
+	return dst
+}
+
+// webpAnimation is an animated WebP: its canvas, ANIM chunk (background
+// color and loop count) and frames
+type webpAnimation struct {
+	width, height int
+	anim          []byte
+	frames        []webpFrame
+}
+
+// webpFrame is an ANMF chunk
+type webpFrame struct {
+	rect     image.Rectangle // on the canvas
+	duration int             // milliseconds
+	flags    byte            // blending and disposal bits
+	data     []byte          // ALPH, VP8 or VP8L chunks
+}
+
+// parseAnimatedWebP reads an animated WebP's frames from their headers; it
+// returns nil for a still WebP. Each frame must lie on the canvas, and its
+// bitstream must be the size its ANMF header says, so the canvas bounds
+// what decoding a frame costs.
+func parseAnimatedWebP(data []byte) (*webpAnimation, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidAnimation
+	}
+
+	var anim *webpAnimation
+	err := webpChunks(data[12:], func(fourCC string, payload []byte) error {
+		switch {
+		case fourCC == "VP8X":
+			if len(payload) < 10 {
+				return errInvalidAnimation
+			}
+			if payload[0]&0x02 != 0 {
+				anim = &webpAnimation{
+					width:  int(le24(payload[4:])) + 1,
+					height: int(le24(payload[7:])) + 1,
+					anim:   make([]byte, 6),
+				}
+			}
+		case anim == nil:
+			// a still image, or no VP8X header yet
+		case fourCC == "ANIM" && len(payload) >= 6:
+			anim.anim = payload[:6]
+		case fourCC == "ANMF":
+			if len(payload) < 16 {
+				return errInvalidAnimation
+			}
+			x, y := int(le24(payload))*2, int(le24(payload[3:]))*2
+			frame := webpFrame{
+				rect:     image.Rect(x, y, x+int(le24(payload[6:]))+1, y+int(le24(payload[9:]))+1),
+				duration: int(le24(payload[12:])),
+				flags:    payload[15],
+				data:     payload[16:],
+			}
+			if !frame.rect.In(image.Rect(0, 0, anim.width, anim.height)) {
+				return fmt.Errorf("%w: frame outside the canvas", errInvalidAnimation)
+			}
+			w, h, err := webpBitstreamSize(frame.data)
+			if err != nil {
+				return err
+			}
+			if w != frame.rect.Dx() || h != frame.rect.Dy() {
+				return fmt.Errorf("%w: %dx%d frame in a %dx%d frame header", errInvalidAnimation, w, h, frame.rect.Dx(), frame.rect.Dy())
+			}
+			anim.frames = append(anim.frames, frame)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if anim != nil && len(anim.frames) == 0 {
+		return nil, errInvalidAnimation
+	}
+	return anim, nil
+}
+
+// resize resizes every frame on its own, so frame bounds, durations,
+// blending and disposal carry over. Frames are re-encoded lossless.
+func (a *webpAnimation) resize(size int) ([]byte, error) {
+	crop := cropSquare(a.width, a.height)
+	scale := float64(size) / float64(crop.Dx())
+
+	var frames [][]byte
+	var durations []int
+	skipped := 0 // duration of leading frames outside the crop
+	for _, frame := range a.frames {
+		r := frame.rect.Intersect(crop)
+		if r.Empty() {
+			// Nothing of the frame shows: its time goes to the previous one
+			if n := len(durations); n > 0 {
+				durations[n-1] += frame.duration
+			} else {
+				skipped += frame.duration
+			}
+			continue
+		}
+
+		img, err := frame.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		// Frame offsets are stored halved, so they must be even
+		dr := scaleRect(r, crop, size)
+		dr.Min.X &^= 1
+		dr.Min.Y &^= 1
+		scaled := image.NewNRGBA(dr)
+		toCanvas := f64.Aff3{
+			scale, 0, float64(frame.rect.Min.X-crop.Min.X) * scale,
+			0, scale, float64(frame.rect.Min.Y-crop.Min.Y) * scale,
+		}
+		draw.CatmullRom.Transform(scaled, toCanvas, img, img.Bounds(), draw.Src, nil)
+
+		var encoded bytes.Buffer
+		if err := nativewebp.Encode(&encoded, scaled, nil); err != nil {
+			return nil, fmt.Errorf("failed to encode WebP frame: %w", err)
+		}
+
+		header := make([]byte, 16)
+		putLE24(header, uint32(dr.Min.X/2))
+		putLE24(header[3:], uint32(dr.Min.Y/2))
+		putLE24(header[6:], uint32(dr.Dx()-1))
+		putLE24(header[9:], uint32(dr.Dy()-1))
+		header[15] = frame.flags
+		// RIFF header stripped: the VP8L chunk
+		frames = append(frames, append(header, encoded.Bytes()[12:]...))
+		durations = append(durations, frame.duration+skipped)
+		skipped = 0
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%w: no frame inside the crop", errInvalidAnimation)
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10 // animation, alpha
+	putLE24(vp8x[4:], uint32(size-1))
+	putLE24(vp8x[7:], uint32(size-1))
+	writeChunk(&body, "VP8X", vp8x)
+	writeChunk(&body, "ANIM", a.anim)
+	for i, frame := range frames {
+		putLE24(frame[12:], uint32(min(durations[i], 1<<24-1)))
+		writeChunk(&body, "ANMF", frame)
+	}
+	return riff(body.Bytes()), nil
+}
+
+// decode decodes the frame as a standalone WebP
+func (f webpFrame) decode() (image.Image, error) {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	hasAlpha := false
+	if err := webpChunks(f.data, func(fourCC string, _ []byte) error {
+		hasAlpha = hasAlpha || fourCC == "ALPH"
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if hasAlpha {
+		// An ALPH chunk is only read after a VP8X header
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		putLE24(vp8x[4:], uint32(f.rect.Dx()-1))
+		putLE24(vp8x[7:], uint32(f.rect.Dy()-1))
+		writeChunk(&body, "VP8X", vp8x)
+	}
+	body.Write(f.data)
+
+	img, err := webp.Decode(bytes.NewReader(riff(body.Bytes())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode WebP frame: %w", err)
+	}
+	return img, nil
+}
+
+// webpBitstreamSize reads the dimensions of a frame's VP8 or VP8L bitstream
+// from its header, without decoding it
+func webpBitstreamSize(data []byte) (width, height int, err error) {
+	err = errInvalidAnimation
+	walkErr := webpChunks(data, func(fourCC string, payload []byte) error {
+		switch {
+		case fourCC == "VP8 " && len(payload) >= 10 && string(payload[3:6]) == "\x9d\x01\x2a":
+			width = int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+			height = int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+			err = nil
+		case fourCC == "VP8L" && len(payload) >= 5 && payload[0] == 0x2f:
+			bits := binary.LittleEndian.Uint32(payload[1:])
+			width = int(bits&0x3fff) + 1
+			height = int(bits>>14&0x3fff) + 1
+			err = nil
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return 0, 0, walkErr
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: frame without a VP8 or VP8L bitstream", err)
+	}
+	return width, height, nil
+}
+
+// webpChunks calls fn for each RIFF chunk in data
+func webpChunks(data []byte, fn func(fourCC string, payload []byte) error) error {
+	for len(data) >= 8 {
+		n := int(binary.LittleEndian.Uint32(data[4:8]))
+		if n > len(data)-8 {
+			return errInvalidAnimation
+		}
+		if err := fn(string(data[:4]), data[8:8+n]); err != nil {
+			return err
+		}
+		data = data[min(8+n+n&1, len(data)):]
+	}
+	return nil
+}
+
+func writeChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// riff wraps a RIFF body ("WEBP" and its chunks)
+func riff(body []byte) []byte {
+	out := make([]byte, 8, 8+len(body))
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func le24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putLE24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
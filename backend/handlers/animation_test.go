@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// webpHeaderFrame is an ANMF frame at x, y of w x h whose VP8L bitstream
+// claims bw x bh and holds no image data
+type webpHeaderFrame struct{ x, y, w, h, bw, bh int }
+
+// animatedWebP builds an animated WebP from frame headers alone
+func animatedWebP(width, height int, frames ...webpHeaderFrame) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02
+	putLE24(vp8x[4:], uint32(width-1))
+	putLE24(vp8x[7:], uint32(height-1))
+	writeChunk(&body, "VP8X", vp8x)
+	writeChunk(&body, "ANIM", make([]byte, 6))
+	for _, f := range frames {
+		vp8l := make([]byte, 5)
+		vp8l[0] = 0x2f
+		binary.LittleEndian.PutUint32(vp8l[1:], uint32(f.bw-1)|uint32(f.bh-1)<<14)
+		var data bytes.Buffer
+		writeChunk(&data, "VP8L", vp8l)
+
+		header := make([]byte, 16)
+		putLE24(header, uint32(f.x/2))
+		putLE24(header[3:], uint32(f.y/2))
+		putLE24(header[6:], uint32(f.w-1))
+		putLE24(header[9:], uint32(f.h-1))
+		writeChunk(&body, "ANMF", append(header, data.Bytes()...))
+	}
+	return riff(body.Bytes())
+}
+
+// None of these frames could be decoded: each is rejected from its headers
+func TestResizeAnimatedWebPChecksHeaders(t *testing.T) {
+	big := webpHeaderFrame{w: 4096, h: 4096, bw: 4096, bh: 4096}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"over the pixel budget", animatedWebP(4096, 4096, big, big, big), errAnimationTooLarge},
+		{"bitstream larger than its frame", animatedWebP(16, 16, webpHeaderFrame{w: 16, h: 16, bw: 16000, bh: 16000}), errInvalidAnimation},
+		{"frame outside the canvas", animatedWebP(16, 16, webpHeaderFrame{x: 8, w: 16, h: 16, bw: 16, bh: 16}), errInvalidAnimation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, ok, err := resizeAnimation(tt.data, 64)
+			if !ok || !errors.Is(err, tt.want) {
+				t.Errorf("resizeAnimation = ok %v, %v; want %v", ok, err, tt.want)
+			}
+		})
+	}
+}
+
+// testGIF returns a two-frame animated GIF
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		frame.SetColorIndex(i, i, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// An animation keeps its format whatever format is asked for, and its ETag
+// says so
+func TestAnimatedAvatarETag(t *testing.T) {
+	store := storage.NewMemoryStore()
+	resolver := &fakeResolver{content: map[string][]byte{}}
+	rec := embedRecord(t, store, resolver, "alice@example.com", 1700000000, testGIF(t))
+	app := avatarApp(newTestAvatarHandler(store, resolver))
+
+	for _, tt := range []struct{ query, etag string }{
+		{"format=png", `"` + rec.Outpoint + `-0-orig"`},
+		{"format=webp", `"` + rec.Outpoint + `-0-orig"`},
+		{"size=64&format=webp", `"` + rec.Outpoint + `-64-orig"`},
+	} {
+		for _, pass := range []string{"fetched", "cached"} {
+			path := "/u/alice@example.com?" + tt.query
+			resp, _ := request(t, app, http.MethodGet, path)
+			if resp.StatusCode != fiber.StatusOK || resp.Header.Get("Content-Type") != "image/gif" {
+				t.Fatalf("GET %s (%s) = %d, %s", path, pass, resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+			if got := resp.Header.Get("ETag"); got != tt.etag {
+				t.Errorf("GET %s (%s) ETag = %s, want %s", path, pass, got, tt.etag)
+			}
+			if resp, _ := request(t, app, http.MethodGet, path, "If-None-Match", tt.etag); resp.StatusCode != fiber.StatusNotModified {
+				t.Errorf("GET %s revalidated = %d, want 304", path, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestResizeAnimatedWebP(t *testing.T) {
+	// Two 8x8 lossless frames on a 16x8 canvas
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10
+	putLE24(vp8x[4:], 15)
+	putLE24(vp8x[7:], 7)
+	writeChunk(&body, "VP8X", vp8x)
+	writeChunk(&body, "ANIM", make([]byte, 6))
+	for i, c := range []color.Color{color.White, color.Black} {
+		img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for p := 0; p < len(img.Pix); p += 4 {
+			r, g, b, a := c.RGBA()
+			img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = byte(r), byte(g), byte(b), byte(a)
+		}
+		var encoded bytes.Buffer
+		if err := nativewebp.Encode(&encoded, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		header := make([]byte, 16)
+		putLE24(header, uint32(i*8/2))
+		putLE24(header[6:], 7)
+		putLE24(header[9:], 7)
+		putLE24(header[12:], 100)
+		writeChunk(&body, "ANMF", append(header, encoded.Bytes()[12:]...))
+	}
+
+	resized, contentType, ok, err := resizeAnimation(riff(body.Bytes()), 4)
+	if !ok || err != nil || contentType != "image/webp" {
+		t.Fatalf("resizeAnimation = %s, ok %v, %v", contentType, ok, err)
+	}
+	anim, err := parseAnimatedWebP(resized)
+	if err != nil || anim == nil {
+		t.Fatalf("resized animation doesn't parse: %v", err)
+	}
+	if anim.width != 4 || anim.height != 4 || len(anim.frames) == 0 {
+		t.Errorf("resized to %dx%d with %d frames", anim.width, anim.height, len(anim.frames))
+	}
+	for _, frame := range anim.frames {
+		if _, err := frame.decode(); err != nil {
+			t.Errorf("frame doesn't decode: %v", err)
+		}
+	}
+}
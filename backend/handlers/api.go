@@ -3,15 +3,18 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 
 	"github.com/b-open-io/bitpic/storage"
 	"github.com/gofiber/fiber/v2"
 )
 
-// APIHandler handles the /api/avatar/:paymail endpoint
+// APIHandler handles the /api/avatar/:paymail and /api/avatar/pk/:pubkey endpoints
 type APIHandler struct {
-	redis     *storage.RedisClient
-	ordfsURL  string
+	store    storage.Store
+	ordfsURL string
 }
 
 // AvatarMetadata represents avatar metadata returned by the API
@@ -20,12 +23,21 @@ type AvatarMetadata struct {
 	Outpoint string `json:"outpoint"`
 	URL      string `json:"url"`
 	Exists   bool   `json:"exists"`
+	PubKey   string `json:"pubkey,omitempty"`
+}
+
+// AvatarHistoryResponse is a page of a paymail's avatar history, newest first.
+// NextCursor is empty on the last page.
+type AvatarHistoryResponse struct {
+	Paymail    string               `json:"paymail"`
+	Items      []storage.AvatarData `json:"items"`
+	NextCursor string               `json:"nextCursor,omitempty"`
 }
 
 // NewAPIHandler creates a new API handler
-func NewAPIHandler(redis *storage.RedisClient, ordfsURL string) *APIHandler {
+func NewAPIHandler(store storage.Store, ordfsURL string) *APIHandler {
 	return &APIHandler{
-		redis:    redis,
+		store:    store,
 		ordfsURL: ordfsURL,
 	}
 }
@@ -39,45 +51,116 @@ func (h *APIHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	outpoint, err := h.redis.GetAvatar(paymail)
+	avatar, err := h.store.GetAvatarData(paymail)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch avatar",
 		})
 	}
 
-	if outpoint == "" {
+	if avatar == nil {
 		return c.JSON(AvatarMetadata{
 			Paymail: paymail,
 			Exists:  false,
 		})
 	}
 
-	return c.JSON(AvatarMetadata{
-		Paymail:  paymail,
-		Outpoint: outpoint,
-		URL:      fmt.Sprintf("%s/content/%s", h.ordfsURL, outpoint),
-		Exists:   true,
+	return c.JSON(h.metadata(paymail, avatar))
+}
+
+// HandlePubKey returns metadata for the avatar most recently set with an
+// identity key, including the paymail it is for.
+func (h *APIHandler) HandlePubKey(c *fiber.Ctx) error {
+	pubKey := strings.ToLower(c.Params("pubkey"))
+	if !isValidPubKey(pubKey) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid public key",
+		})
+	}
+
+	avatar, err := storage.CurrentAvatarByPubKey(h.store, pubKey)
+	if err != nil {
+		log.Printf("Pubkey lookup failed: pubkey=%s error=%v", pubKey, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch avatar",
+		})
+	}
+
+	if avatar == nil {
+		return c.JSON(AvatarMetadata{
+			Exists: false,
+			PubKey: pubKey,
+		})
+	}
+
+	return c.JSON(h.metadata(avatar.Paymail, avatar))
+}
+
+// History returns every verified avatar record for a paymail, newest first.
+// Supports query parameters:
+//   - cursor: nextCursor from the previous page (omit for the first page)
+//   - limit: page size (default 20, max 100)
+func (h *APIHandler) History(c *fiber.Ctx) error {
+	paymail := c.Params("paymail")
+	if paymail == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Paymail is required",
+		})
+	}
+
+	limit, err := strconv.ParseInt(c.Query("limit", "20"), 10, 64)
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	items, next, err := h.store.GetAvatarHistory(paymail, c.Query("cursor"), limit)
+	if err != nil {
+		log.Printf("History lookup failed: paymail=%s error=%v", paymail, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch avatar history",
+		})
+	}
+
+	if items == nil {
+		items = []storage.AvatarData{}
+	}
+
+	return c.JSON(AvatarHistoryResponse{
+		Paymail:    paymail,
+		Items:      items,
+		NextCursor: next,
 	})
 }
 
 // GetAvatarData fetches avatar metadata
 func (h *APIHandler) GetAvatarData(paymail string) ([]byte, error) {
-	outpoint, err := h.redis.GetAvatar(paymail)
+	avatar, err := h.store.GetAvatarData(paymail)
 	if err != nil {
 		return nil, err
 	}
 
-	if outpoint == "" {
+	if avatar == nil {
 		return nil, fmt.Errorf("avatar not found")
 	}
 
+	return json.Marshal(h.metadata(paymail, avatar))
+}
+
+// metadata describes an avatar. Hash references have no ORDFS URL; they are
+// only served by /u/:paymail.
+func (h *APIHandler) metadata(paymail string, avatar *storage.AvatarData) AvatarMetadata {
+	outpoint := avatar.ContentOutpoint()
 	data := AvatarMetadata{
 		Paymail:  paymail,
 		Outpoint: outpoint,
-		URL:      fmt.Sprintf("%s/content/%s", h.ordfsURL, outpoint),
 		Exists:   true,
+		PubKey:   avatar.PubKey,
 	}
-
-	return json.Marshal(data)
+	if !avatar.IsHashRef() {
+		data.URL = fmt.Sprintf("%s/content/%s", h.ordfsURL, outpoint)
+	}
+	return data
 }
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"strings"
+
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/bsv-blockchain/go-sdk/transaction/broadcaster"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ARCCallbackHandler handles the /api/arc/callback endpoint: status updates
+// ARC pushes for transactions submitted in broadcast mode. They settle
+// pending avatars without waiting for JungleBus or the reconciler.
+type ARCCallbackHandler struct {
+	store storage.Store
+	token string
+}
+
+// ARCCallback is the body ARC posts for a status change.
+type ARCCallback struct {
+	Timestamp    string   `json:"timestamp"`
+	TxID         string   `json:"txid"`
+	TxStatus     string   `json:"txStatus"`
+	BlockHash    string   `json:"blockHash,omitempty"`
+	BlockHeight  uint64   `json:"blockHeight,omitempty"`
+	ExtraInfo    string   `json:"extraInfo,omitempty"`
+	CompetingTxs []string `json:"competingTxs,omitempty"`
+}
+
+// NewARCCallbackHandler creates a new ARC callback handler. token is the
+// callback token registered with ARC; requests must present it as a bearer
+// token. An empty token disables the endpoint.
+func NewARCCallbackHandler(store storage.Store, token string) *ARCCallbackHandler {
+	return &ARCCallbackHandler{
+		store: store,
+		token: token,
+	}
+}
+
+// Handle applies an ARC status callback to the avatars its tx created.
+//
+//   - MINED confirms the avatar.
+//   - REJECTED and DOUBLE_SPEND_ATTEMPTED remove a still-unconfirmed avatar and
+//     restore the paymail's previous one. Should the tx be mined after all,
+//     JungleBus indexes it again.
+//
+// Other statuses are acknowledged and ignored.
+func (h *ARCCallbackHandler) Handle(c *fiber.Ctx) error {
+	if h.token == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "ARC callbacks are not enabled",
+		})
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid callback token",
+		})
+	}
+
+	var cb ARCCallback
+	if err := c.BodyParser(&cb); err != nil || cb.TxID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid callback body",
+		})
+	}
+
+	records, err := h.store.GetAvatarsByTxID(cb.TxID)
+	if err != nil {
+		log.Printf("ARC callback: failed to look up %s: %v", cb.TxID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to look up transaction",
+		})
+	}
+
+	// Not (or no longer) indexed means nothing to update; a batch tx has a
+	// record per avatar it set.
+	for i := range records {
+		data := &records[i]
+		switch broadcaster.ArcStatus(cb.TxStatus) {
+		case broadcaster.MINED:
+			if cb.BlockHeight == 0 || cb.BlockHash == "" {
+				break
+			}
+			data.Confirmed = true
+			data.BlockHeight = cb.BlockHeight
+			data.BlockHash = cb.BlockHash
+			if err := h.store.SetAvatar(data); err != nil {
+				log.Printf("ARC callback: failed to confirm %s: %v", data.Paymail, err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update avatar",
+				})
+			}
+			log.Printf("ARC callback: confirmed %s @ block %d", data.Paymail, cb.BlockHeight)
+
+		case broadcaster.REJECTED, broadcaster.DOUBLE_SPEND_ATTEMPTED:
+			if data.Confirmed {
+				break // ours was mined; the competing tx lost
+			}
+			current, err := h.store.RemoveAvatar(data.Paymail, data.Outpoint)
+			if err != nil {
+				log.Printf("ARC callback: failed to remove %s: %v", data.Outpoint, err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update avatar",
+				})
+			}
+			restored := "none"
+			if current != nil {
+				restored = current.Outpoint
+			}
+			log.Printf("ARC callback: %s %s (%s); removed avatar for %s, current now %s",
+				cb.TxID, cb.TxStatus, cb.ExtraInfo, data.Paymail, restored)
+		}
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
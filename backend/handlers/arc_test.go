@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// postCallback posts body to /api/arc/callback with a bearer token ("" for
+// none) and returns the status
+func postCallback(t *testing.T, h *ARCCallbackHandler, token string, body any) int {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/arc/callback", h.Handle)
+
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/arc/callback", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestARCCallbackAuth(t *testing.T) {
+	cb := ARCCallback{TxID: strings.Repeat("a", 64), TxStatus: "MINED"}
+
+	if code := postCallback(t, NewARCCallbackHandler(storage.NewMemoryStore(), ""), "secret", cb); code != fiber.StatusNotFound {
+		t.Errorf("disabled: status = %d, want 404", code)
+	}
+	h := NewARCCallbackHandler(storage.NewMemoryStore(), "secret")
+	for _, token := range []string{"", "wrong", "secret-but-longer"} {
+		if code := postCallback(t, h, token, cb); code != fiber.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, code)
+		}
+	}
+	if code := postCallback(t, h, "secret", ARCCallback{TxStatus: "MINED"}); code != fiber.StatusBadRequest {
+		t.Errorf("no txid: status = %d, want 400", code)
+	}
+	if code := postCallback(t, h, "secret", cb); code != fiber.StatusOK {
+		t.Errorf("unknown txid: status = %d, want 200", code)
+	}
+}
+
+func TestARCCallbackStatuses(t *testing.T) {
+	older := storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("a", 64), Timestamp: 100,
+		Confirmed: true, BlockHeight: 800000, BlockHash: "hash-a"}
+	newer := storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("b", 64), Timestamp: 200}
+	older.Outpoint, newer.Outpoint = older.TxID+"_0", newer.TxID+"_0"
+
+	tests := []struct {
+		name        string
+		cb          ARCCallback
+		wantCurrent string // current avatar's txid afterwards
+		confirmed   bool
+		block       uint64
+	}{
+		{
+			name:        "mined",
+			cb:          ARCCallback{TxID: newer.TxID, TxStatus: "MINED", BlockHeight: 800001, BlockHash: "hash-b"},
+			wantCurrent: newer.TxID,
+			confirmed:   true,
+			block:       800001,
+		},
+		{
+			name:        "mined without a block",
+			cb:          ARCCallback{TxID: newer.TxID, TxStatus: "MINED", BlockHeight: 800001},
+			wantCurrent: newer.TxID,
+		},
+		{
+			name:        "rejected restores the previous avatar",
+			cb:          ARCCallback{TxID: newer.TxID, TxStatus: "REJECTED", ExtraInfo: "missing inputs"},
+			wantCurrent: older.TxID,
+			confirmed:   true,
+			block:       800000,
+		},
+		{
+			name:        "double spend restores the previous avatar",
+			cb:          ARCCallback{TxID: newer.TxID, TxStatus: "DOUBLE_SPEND_ATTEMPTED"},
+			wantCurrent: older.TxID,
+			confirmed:   true,
+			block:       800000,
+		},
+		{
+			name:        "other statuses are ignored",
+			cb:          ARCCallback{TxID: newer.TxID, TxStatus: "SEEN_ON_NETWORK"},
+			wantCurrent: newer.TxID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStore()
+			for _, rec := range []storage.AvatarData{older, newer} {
+				if err := store.SetAvatar(&rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if code := postCallback(t, NewARCCallbackHandler(store, "secret"), "secret", tt.cb); code != fiber.StatusOK {
+				t.Fatalf("status = %d, want 200", code)
+			}
+			current, err := store.GetAvatarData("alice@example.com")
+			if err != nil || current == nil {
+				t.Fatalf("GetAvatarData = %v, %v", current, err)
+			}
+			if current.TxID != tt.wantCurrent || current.Confirmed != tt.confirmed || current.BlockHeight != tt.block {
+				t.Errorf("current = %s confirmed %v @ %d; want %s confirmed %v @ %d",
+					current.TxID, current.Confirmed, current.BlockHeight, tt.wantCurrent, tt.confirmed, tt.block)
+			}
+		})
+	}
+}
+
+// A confirmed record is left alone when a competing tx is reported
+func TestARCCallbackKeepsConfirmed(t *testing.T) {
+	store := storage.NewMemoryStore()
+	rec := storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("c", 64), Timestamp: 100,
+		Confirmed: true, BlockHeight: 800000, BlockHash: "hash-c"}
+	rec.Outpoint = rec.TxID + "_0"
+	if err := store.SetAvatar(&rec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{"REJECTED", "DOUBLE_SPEND_ATTEMPTED"} {
+		if code := postCallback(t, NewARCCallbackHandler(store, "secret"), "secret", ARCCallback{TxID: rec.TxID, TxStatus: status}); code != fiber.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", status, code)
+		}
+		if current, _ := store.GetAvatarData("alice@example.com"); current == nil || current.TxID != rec.TxID || !current.Confirmed {
+			t.Errorf("%s: current = %+v, want the confirmed record untouched", status, current)
+		}
+	}
+}
@@ -2,32 +2,44 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
-	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/content"
+	"github.com/b-open-io/bitpic/defaults"
+	"github.com/b-open-io/bitpic/diagnostics"
 	"github.com/b-open-io/bitpic/storage"
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/image/draw"
 )
 
-// AvatarHandler handles the /u/:paymail endpoint
+// AvatarHandler handles the /u/:paymail, /pk/:pubkey and /c/:outpoint endpoints
 type AvatarHandler struct {
-	redis    *storage.RedisClient
-	ordfsURL string
+	store    storage.Store
+	resolver content.Resolver
+	blobs    storage.BlobStore
 	cacheTTL time.Duration
+	counters *diagnostics.Counters
+
+	// Whether /u/ and /pk/ redirect to the /c/ URL of the current avatar
+	redirect bool
+	// Which d= URLs are used, and how; nil ignores them all
+	fallbacks *FallbackPolicy
 }
 
-// maxImageBytes caps the size of an ordinal we will fetch/serve as an avatar.
-// Prevents a huge referenced ordinal from exhausting memory/bandwidth or
-// poisoning the image cache.
-const maxImageBytes = 10 << 20 // 10 MiB
+// defaultImageSize is the size built-in defaults are drawn at when no size
+// is asked for
+const defaultImageSize = 128
 
 // Standard avatar sizes - these are cached
 var allowedSizes = map[int]bool{
@@ -38,25 +50,112 @@ var allowedSizes = map[int]bool{
 	512: true,
 }
 
-// NewAvatarHandler creates a new avatar handler
-func NewAvatarHandler(redis *storage.RedisClient, ordfsURL string, cacheTTL time.Duration) *AvatarHandler {
+// NewAvatarHandler creates a new avatar handler. Images come from resolver;
+// blobs, if not nil, is the local content store verified embeds fetched from
+// elsewhere are added to.
+func NewAvatarHandler(store storage.Store, resolver content.Resolver, blobs storage.BlobStore, cacheTTL time.Duration, counters *diagnostics.Counters) *AvatarHandler {
 	return &AvatarHandler{
-		redis:    redis,
-		ordfsURL: ordfsURL,
+		store:    store,
+		resolver: resolver,
+		blobs:    blobs,
 		cacheTTL: cacheTTL,
+		counters: counters,
 	}
 }
 
+// SetRedirect makes /u/:paymail and /pk/:pubkey redirect to the immutable
+// /c/:outpoint URL of the current avatar instead of serving it.
+func (h *AvatarHandler) SetRedirect(enabled bool) {
+	h.redirect = enabled
+}
+
+// SetFallbackPolicy sets which d= URLs are redirected to or proxied. Without
+// one, URLs are ignored.
+func (h *AvatarHandler) SetFallbackPolicy(policy *FallbackPolicy) {
+	h.fallbacks = policy
+}
+
 // Handle fetches and returns the avatar image
 // Supports query parameters:
 //   - size: 32, 64, 128, 256, 512 (resize to square)
-//   - d: URL for default/fallback image when avatar doesn't exist
+//   - format: webp, png, jpeg or gif (otherwise negotiated from Accept)
+//   - d: default when the avatar doesn't exist or can't be served: a URL the
+//     fallback policy allows, a built-in style (identicon, initials, retro,
+//     monsterid, blank) or 404
 func (h *AvatarHandler) Handle(c *fiber.Ctx) error {
 	paymail := c.Params("paymail")
 	if paymail == "" {
 		return c.Status(fiber.StatusBadRequest).SendString("Paymail is required")
 	}
 
+	// Get avatar data from the store
+	avatarData, err := h.store.GetAvatarData(paymail)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch avatar")
+	}
+
+	return h.serve(c, paymail, avatarData, false)
+}
+
+// HandlePubKey returns the avatar most recently set with an identity key, for
+// whichever paymail it was for. Takes the same query parameters as Handle.
+func (h *AvatarHandler) HandlePubKey(c *fiber.Ctx) error {
+	pubKey := strings.ToLower(c.Params("pubkey"))
+	if !isValidPubKey(pubKey) {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid public key")
+	}
+
+	avatarData, err := storage.CurrentAvatarByPubKey(h.store, pubKey)
+	if err != nil {
+		log.Printf("Pubkey lookup failed: pubkey=%s error=%v", pubKey, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch avatar")
+	}
+
+	paymail := pubKey
+	if avatarData != nil {
+		paymail = avatarData.Paymail
+	}
+	return h.serve(c, paymail, avatarData, false)
+}
+
+// HandleContent returns the image of the avatar record at a BitPic outpoint
+// (/c/:outpoint). The record never changes, so the response is immutable.
+// Takes the same query parameters as Handle.
+func (h *AvatarHandler) HandleContent(c *fiber.Ctx) error {
+	outpoint := strings.ToLower(c.Params("outpoint"))
+	txid, vout, ok := strings.Cut(outpoint, "_")
+	if _, err := hex.DecodeString(txid); !ok || err != nil || len(txid) != 64 {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid outpoint")
+	}
+	if _, err := strconv.ParseUint(vout, 10, 32); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid outpoint")
+	}
+
+	records, err := h.store.GetAvatarsByTxID(txid)
+	if err != nil {
+		log.Printf("Outpoint lookup failed: outpoint=%s error=%v", outpoint, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch avatar")
+	}
+
+	var avatarData *storage.AvatarData
+	for i := range records {
+		if records[i].Outpoint == outpoint {
+			avatarData = &records[i]
+			break
+		}
+	}
+
+	paymail := outpoint
+	if avatarData != nil {
+		paymail = avatarData.Paymail
+	}
+	return h.serve(c, paymail, avatarData, true)
+}
+
+// serve responds with avatarData's image, or the default image or a 404 if
+// it is nil. An immutable response is served from /c/:outpoint; a mutable
+// one redirects there when redirects are on.
+func (h *AvatarHandler) serve(c *fiber.Ctx, paymail string, avatarData *storage.AvatarData, immutable bool) error {
 	// Parse size parameter (default: original size, 0 means no resize)
 	sizeStr := c.Query("size", "0")
 	size, err := strconv.Atoi(sizeStr)
@@ -70,85 +169,99 @@ func (h *AvatarHandler) Handle(c *fiber.Ctx) error {
 		size = nearestAllowedSize(size)
 	}
 
-	// Get default image URL parameter
-	defaultURL := c.Query("d", "")
-
-	// Get avatar data from Redis
-	avatarData, err := h.redis.GetAvatarData(paymail)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch avatar")
+	variant, negotiated := negotiateFormat(c, size)
+	if negotiated {
+		c.Vary("Accept")
 	}
 
-	// Handle missing avatar - redirect to default or return 404
+	// Get default image parameter
+	fb := fallback{d: c.Query("d", ""), seed: paymail, size: size, variant: variant}
+
+	// Handle missing avatar - serve the default or return 404
 	if avatarData == nil {
-		if defaultURL != "" {
-			return c.Redirect(defaultURL, fiber.StatusTemporaryRedirect)
+		if ok, err := h.fallback(c, fb); ok {
+			return err
 		}
 		return c.Status(fiber.StatusNotFound).SendString("Avatar not found")
 	}
 
-	// Determine the actual outpoint to fetch
-	// If this is a reference, use the RefOrigin; otherwise use the Outpoint
-	outpoint := avatarData.Outpoint
-	if avatarData.IsRef && avatarData.RefOrigin != "" {
-		outpoint = avatarData.RefOrigin
+	if h.redirect && !immutable {
+		target := "/c/" + avatarData.Outpoint
+		if query := c.Context().QueryArgs().String(); query != "" {
+			target += "?" + query
+		}
+		c.Set("Cache-Control", mutableCacheControl)
+		return c.Redirect(target, fiber.StatusFound)
+	}
+
+	// An avatar record's image never changes: the record, size and format
+	// identify the response. Only /c/ gets a Last-Modified: /u/ and /pk/ can
+	// go back to an older record (when an ARC reject or a reorg removes the
+	// newer one), and its timestamp would pass If-Modified-Since for clients
+	// still holding the newer image.
+	etag := imageETag(avatarData.Outpoint, size, variant)
+	var lastModified time.Time
+	if immutable {
+		lastModified = time.Unix(avatarData.Timestamp, 0).UTC()
+	}
+	if tag, ok := notModified(c, lastModified, etagCandidates(avatarData.Outpoint, size, variant)...); ok {
+		setCacheHeaders(c, tag, lastModified, immutable)
+		return c.SendStatus(fiber.StatusNotModified)
 	}
 
-	// Build cache key with size
+	// Images are cached under the content outpoint; one that may have come
+	// from any of several sources, under the BitPic outpoint
+	refs := sources(avatarData)
+	outpoint := avatarData.ContentOutpoint()
+	if len(refs) > 1 {
+		outpoint = avatarData.Outpoint
+	}
+
+	// Build cache key with size and format
 	cacheKey := outpoint
 	if size > 0 {
 		cacheKey = fmt.Sprintf("%s_%d", outpoint, size)
 	}
+	if variant != formatSource {
+		cacheKey = fmt.Sprintf("%s_%d_%s", outpoint, size, variant)
+	}
 
-	// Check cache first
-	cached, err := h.redis.GetCachedImage(cacheKey)
-	if err == nil && cached != nil {
+	// Check cache first (variants are only made from verified originals).
+	// HEAD takes the same path as GET, so its status, ETag and Content-Type
+	// are the GET's; only the body is dropped.
+	cached, err := h.store.GetCachedImage(cacheKey)
+	if err == nil && cached != nil && (cacheKey != outpoint || verified(cached, refs)) {
 		contentType := detectContentType(cached)
 		if contentType == "" || !isAllowedContentType(contentType) {
 			return c.Status(fiber.StatusUnsupportedMediaType).SendString("Unsupported image format")
 		}
 
-		c.Set("Content-Type", contentType)
-		c.Set("Content-Security-Policy", "default-src 'none'")
-		c.Set("X-Content-Type-Options", "nosniff")
-		c.Set("Cache-Control", "public, max-age=2592000") // 30 days
-		return c.Send(cached)
+		setCacheHeaders(c, servedETag(avatarData.Outpoint, size, variant, contentType), lastModified, immutable)
+		return sendImage(c, cached, contentType)
 	}
 
-	// Fetch original from ORDFS (or cache)
+	// Fetch original from the cache or the content resolver
 	var imageData []byte
-	cached, err = h.redis.GetCachedImage(outpoint)
-	if err == nil && cached != nil {
+	if cached, err = h.store.GetCachedImage(outpoint); err == nil && cached != nil && verified(cached, refs) {
 		imageData = cached
 	} else {
-		// Fetch from ORDFS
-		url := fmt.Sprintf("%s/content/%s", h.ordfsURL, outpoint)
-		resp, err := http.Get(url)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch image from ORDFS")
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return c.Status(fiber.StatusNotFound).SendString("Image not found on ORDFS")
-		}
-
-		// Reject oversized content up front when the length is advertised.
-		if resp.ContentLength > maxImageBytes {
-			return h.tooLarge(c, defaultURL)
-		}
-
-		// Read with a hard cap (handles chunked / missing Content-Length).
-		imageData, err = io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to read image data")
-		}
-		if len(imageData) > maxImageBytes {
-			return h.tooLarge(c, defaultURL)
+		imageData, err = h.resolve(c.UserContext(), paymail, refs)
+		switch {
+		case errors.Is(err, content.ErrNotFound):
+			return c.Status(fiber.StatusNotFound).SendString("Image not found")
+		case errors.Is(err, content.ErrTooLarge):
+			return h.tooLarge(c, fb)
+		case errors.Is(err, content.ErrHashMismatch):
+			return h.failedVerification(c, fb)
+		case errors.Is(err, errUnsupportedImage):
+			return c.Status(fiber.StatusUnsupportedMediaType).SendString("Unsupported image format")
+		case err != nil:
+			log.Printf("Failed to fetch image for %s: %v", paymail, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch image")
 		}
 
-		// Cache original
-		if err := h.redis.CacheImage(outpoint, imageData, h.cacheTTL); err != nil {
+		// Cache original; a verified embed is kept for good
+		if err := h.store.CacheImage(outpoint, imageData, h.cacheTTL); err != nil {
 			fmt.Printf("Failed to cache original image: %v\n", err)
 		}
 	}
@@ -158,33 +271,278 @@ func (h *AvatarHandler) Handle(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnsupportedMediaType).SendString("Unsupported image format")
 	}
 
-	// Resize if requested
-	if size > 0 {
-		resized, err := resizeImage(imageData, size, contentType)
+	// Resize and convert if requested
+	if size > 0 || variant != formatSource {
+		resized, resizedType, err := resizeImage(imageData, size, outputType(variant, contentType))
 		if err != nil {
 			fmt.Printf("Failed to resize image: %v\n", err)
 			// Fall back to original
+			etag = imageETag(avatarData.Outpoint, 0, formatSource)
 		} else {
 			imageData = resized
+			contentType = resizedType
+			etag = servedETag(avatarData.Outpoint, size, variant, contentType)
 			// Cache resized version
-			if err := h.redis.CacheImage(cacheKey, imageData, h.cacheTTL); err != nil {
+			if err := h.store.CacheImage(cacheKey, imageData, h.cacheTTL); err != nil {
 				fmt.Printf("Failed to cache resized image: %v\n", err)
 			}
 		}
 	}
 
+	setCacheHeaders(c, etag, lastModified, immutable)
+	return sendImage(c, imageData, contentType)
+}
+
+// Cache lifetimes: /u/ and /pk/ responses change with the avatar, so caches
+// revalidate them after a few minutes; /c/ responses never change.
+const (
+	mutableCacheControl   = "public, max-age=300"
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// imageETag returns the strong ETag of an avatar image: the BitPic outpoint,
+// the size (0 for the original) and the format variant served
+func imageETag(outpoint string, size int, variant string) string {
+	if variant == formatSource {
+		variant = "orig"
+	}
+	return fmt.Sprintf(`"%s-%d-%s"`, outpoint, size, variant)
+}
+
+// servedETag returns the ETag of an image variant that came out as
+// contentType. Animations keep their own format whatever format was asked
+// for, so they are tagged as the source format rather than the variant.
+func servedETag(outpoint string, size int, variant, contentType string) string {
+	if variant != formatSource && contentType != outputType(variant, contentType) {
+		variant = formatSource
+	}
+	return imageETag(outpoint, size, variant)
+}
+
+// etagCandidates returns the ETags a response for a variant may carry before
+// its image is known: the variant's own, and those of an animation kept in
+// its own format or of the original served when resizing fails
+func etagCandidates(outpoint string, size int, variant string) []string {
+	etags := []string{imageETag(outpoint, size, variant)}
+	if variant != formatSource {
+		etags = append(etags, imageETag(outpoint, size, formatSource))
+	}
+	if size > 0 || variant != formatSource {
+		etags = append(etags, imageETag(outpoint, 0, formatSource))
+	}
+	return etags
+}
+
+// setCacheHeaders sets the validators and lifetime of an avatar response. A
+// zero lastModified is left out.
+func setCacheHeaders(c *fiber.Ctx, etag string, lastModified time.Time, immutable bool) {
+	c.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if immutable {
+		c.Set("Cache-Control", immutableCacheControl)
+	} else {
+		c.Set("Cache-Control", mutableCacheControl)
+	}
+}
+
+// notModified evaluates If-None-Match, or failing that If-Modified-Since
+// (unless lastModified is zero), against an avatar response's validators
+// (RFC 9110 section 13.2.2). It returns the ETag to send with the 304: the
+// one that matched, or the first.
+func notModified(c *fiber.Ctx, lastModified time.Time, etags ...string) (string, bool) {
+	if noneMatch := c.Get("If-None-Match"); noneMatch != "" {
+		for _, etag := range etags {
+			if etagMatches(noneMatch, etag) {
+				return etag, true
+			}
+		}
+		return "", false
+	}
+	if lastModified.IsZero() {
+		return "", false
+	}
+	if since, err := http.ParseTime(c.Get("If-Modified-Since")); err == nil {
+		return etags[0], !lastModified.After(since)
+	}
+	return "", false
+}
+
+// etagMatches reports whether an If-None-Match header lists etag (weakly)
+func etagMatches(noneMatch, etag string) bool {
+	for _, tag := range strings.Split(noneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendImage writes an image with headers that keep browsers from treating it
+// as anything else
+func sendImage(c *fiber.Ctx, data []byte, contentType string) error {
 	c.Set("Content-Type", contentType)
 	c.Set("Content-Security-Policy", "default-src 'none'")
 	c.Set("X-Content-Type-Options", "nosniff")
-	c.Set("Cache-Control", "public, max-age=2592000") // 30 days
-	return c.Send(imageData)
+	return c.Send(data)
+}
+
+// isValidPubKey reports whether s is a hex public key, compressed (66 chars)
+// or uncompressed (130)
+func isValidPubKey(s string) bool {
+	if len(s) != 66 && len(s) != 130 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
 }
 
-// tooLarge responds when a referenced ordinal exceeds the size cap: redirect to
-// the default image if provided, otherwise 413.
-func (h *AvatarHandler) tooLarge(c *fiber.Ctx, defaultURL string) error {
-	if defaultURL != "" {
-		return c.Redirect(defaultURL, fiber.StatusTemporaryRedirect)
+// errUnsupportedImage: the content isn't an image format we serve.
+var errUnsupportedImage = errors.New("unsupported image format")
+
+// sources returns where an avatar's image can come from, in the order to try:
+// the embed itself, the one referenced content, or each URI of a uri-list.
+func sources(avatar *storage.AvatarData) []content.Ref {
+	if len(avatar.RefURIs) == 0 {
+		ref := content.Ref{Outpoint: avatar.ContentOutpoint(), Hash: avatar.ImageHash}
+		if avatar.IsHashRef() {
+			ref.Outpoint = ""
+		}
+		return []content.Ref{ref}
+	}
+	refs := make([]content.Ref, 0, len(avatar.RefURIs))
+	for _, uri := range avatar.RefURIs {
+		if origin, hash, ok := bitpic.ParseRefURI(uri); ok {
+			refs = append(refs, content.Ref{Outpoint: origin, Hash: hash})
+		}
+	}
+	return refs
+}
+
+// verified reports whether data is something refs could resolve to: it
+// matches one's hash, or one (an ord:// or b:// reference) has none.
+func verified(data []byte, refs []content.Ref) bool {
+	for _, ref := range refs {
+		if content.Matches(data, ref.Hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the first of refs that resolves to an allowed image, with
+// hash-checked content verified. Verified embeds and c:// content are added
+// to the content store. If none resolves, the first error other than
+// content.ErrNotFound is returned.
+func (h *AvatarHandler) resolve(ctx context.Context, paymail string, refs []content.Ref) ([]byte, error) {
+	err := content.ErrNotFound
+	for _, ref := range refs {
+		data, rerr := h.resolver.Resolve(ctx, ref)
+		switch {
+		case rerr != nil:
+			// try the next source
+		case !content.Matches(data, ref.Hash):
+			// The bytes are covered by the signature: never cache or serve
+			// anything else. (A content.Chain already checks.)
+			h.counters.ContentHashMismatch()
+			log.Printf("Content hash mismatch for %s (%s): expected %s", paymail, h.resolver.Name(), ref.Hash)
+			rerr = content.ErrHashMismatch
+		case !isAllowedContentType(detectContentType(data)):
+			rerr = errUnsupportedImage
+		default:
+			if h.blobs != nil && ref.Hash != "" {
+				if _, err := h.blobs.PutBlob(data); err != nil {
+					log.Printf("Failed to store image for %s: %v", ref, err)
+				}
+			}
+			return data, nil
+		}
+		if errors.Is(err, content.ErrNotFound) {
+			err = rerr
+		}
+	}
+	return nil, err
+}
+
+// fallback is what d= asks for in place of an avatar that is missing or
+// can't be served
+type fallback struct {
+	d       string // a defaults style or a URL
+	seed    string // the paymail (or key or outpoint) a default is drawn for
+	size    int
+	variant string
+}
+
+// fallback responds with the default: a built-in image for a style name,
+// a redirect to or the proxied image of a URL the fallback policy allows. It
+// returns false if there is none (no d, d=404 or a URL that isn't allowed).
+func (h *AvatarHandler) fallback(c *fiber.Ctx, fb fallback) (bool, error) {
+	switch {
+	case defaults.IsStyle(fb.d):
+		return true, h.serveDefault(c, fb)
+	case fb.d == "" || fb.d == "404":
+		return false, nil
+	}
+
+	// Never redirect to or fetch just any URL
+	u, ok := h.fallbacks.allow(fb.d)
+	if !ok {
+		return false, nil
+	}
+	if h.fallbacks.proxy {
+		return true, h.serveProxied(c, fb, u)
+	}
+	return true, c.Redirect(u.String(), fiber.StatusTemporaryRedirect)
+}
+
+// serveDefault responds with a built-in default image, drawn at the
+// requested size (defaultImageSize for none). Defaults aren't put in the
+// image cache: any string can be asked for one, so caching would let clients
+// fill the store, and they are cheap to draw again.
+func (h *AvatarHandler) serveDefault(c *fiber.Ctx, fb fallback) error {
+	size := fb.size
+	if size == 0 {
+		size = defaultImageSize
+	}
+	contentType := outputType(fb.variant, "image/png")
+	seed := sha256.Sum256([]byte(strings.ToLower(fb.seed)))
+	// Drawn from the seed alone, but replaced once the paymail sets an avatar
+	etag := fmt.Sprintf(`"default_%s_%x_%d_%s"`, fb.d, seed[:16], size, strings.TrimPrefix(contentType, "image/"))
+	c.Set("ETag", etag)
+	c.Set("Cache-Control", mutableCacheControl)
+	if etagMatches(c.Get("If-None-Match"), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	img, err := defaults.Generate(fb.d, fb.seed, size)
+	if err != nil {
+		log.Printf("Failed to draw %s default for %s: %v", fb.d, fb.seed, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to draw default image")
+	}
+	data, err := encodeImage(img, contentType)
+	if err != nil {
+		log.Printf("Failed to encode %s default for %s: %v", fb.d, fb.seed, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to draw default image")
+	}
+	return sendImage(c, data, contentType)
+}
+
+// failedVerification responds when the only content found doesn't match the
+// signed image hash: serve the default image if provided, otherwise 502.
+func (h *AvatarHandler) failedVerification(c *fiber.Ctx, fb fallback) error {
+	if ok, err := h.fallback(c, fb); ok {
+		return err
+	}
+	return c.Status(fiber.StatusBadGateway).SendString("Image failed verification")
+}
+
+// tooLarge responds when a referenced ordinal exceeds the size cap: serve the
+// default image if provided, otherwise 413.
+func (h *AvatarHandler) tooLarge(c *fiber.Ctx, fb fallback) error {
+	if ok, err := h.fallback(c, fb); ok {
+		return err
 	}
 	return c.Status(fiber.StatusRequestEntityTooLarge).SendString("Image too large")
 }
@@ -212,12 +570,23 @@ func abs(x int) int {
 	return x
 }
 
-// resizeImage resizes an image to the specified size (square, maintains aspect ratio with crop)
-func resizeImage(data []byte, size int, contentType string) ([]byte, error) {
+// resizeImage resizes an image to the specified size (square, maintains aspect
+// ratio with crop) and encodes it as contentType. Size 0 keeps the dimensions.
+// Animations are resized frame by frame and keep their own format; the
+// content type of the result is returned.
+func resizeImage(data []byte, size int, contentType string) ([]byte, string, error) {
+	if resized, animType, ok, err := resizeAnimation(data, size); ok {
+		return resized, animType, err
+	}
+
 	// Decode image
 	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if size == 0 {
+		resized, err := encodeImage(img, contentType)
+		return resized, contentType, err
 	}
 
 	// Create square output
@@ -225,19 +594,7 @@ func resizeImage(data []byte, size int, contentType string) ([]byte, error) {
 
 	// Calculate crop region for center square
 	bounds := img.Bounds()
-	srcW := bounds.Dx()
-	srcH := bounds.Dy()
-
-	var cropRect image.Rectangle
-	if srcW > srcH {
-		// Landscape - crop sides
-		offset := (srcW - srcH) / 2
-		cropRect = image.Rect(offset, 0, offset+srcH, srcH)
-	} else {
-		// Portrait or square - crop top/bottom
-		offset := (srcH - srcW) / 2
-		cropRect = image.Rect(0, offset, srcW, offset+srcW)
-	}
+	cropRect := cropSquare(bounds.Dx(), bounds.Dy())
 
 	// Create cropped subimage
 	type subImager interface {
@@ -250,25 +607,20 @@ func resizeImage(data []byte, size int, contentType string) ([]byte, error) {
 	// Scale to target size with high-quality interpolation
 	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
 
-	// Encode back to original format
-	var buf bytes.Buffer
-	switch contentType {
-	case "image/png":
-		err = png.Encode(&buf, dst)
-	case "image/jpeg":
-		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
-	case "image/gif":
-		err = gif.Encode(&buf, dst, nil)
-	default:
-		// Default to JPEG for unknown types
-		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
-	}
+	resized, err := encodeImage(dst, contentType)
+	return resized, contentType, err
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode image: %w", err)
+// cropSquare returns the centered square of a width x height image
+func cropSquare(width, height int) image.Rectangle {
+	if width > height {
+		// Landscape - crop sides
+		offset := (width - height) / 2
+		return image.Rect(offset, 0, offset+height, height)
 	}
-
-	return buf.Bytes(), nil
+	// Portrait or square - crop top/bottom
+	offset := (height - width) / 2
+	return image.Rect(0, offset, width, offset+width)
 }
 
 // detectContentType detects the content type of image data
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/b-open-io/bitpic/content"
+	"github.com/b-open-io/bitpic/diagnostics"
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testPubKey = "02aa00000000000000000000000000000000000000000000000000000000000001"
+
+// testPNG returns a w x h PNG filled with c
+func testPNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fakeResolver serves content by outpoint, counting requests
+type fakeResolver struct {
+	content map[string][]byte
+	hits    atomic.Int32
+}
+
+func (f *fakeResolver) Name() string { return "fake" }
+
+func (f *fakeResolver) Resolve(_ context.Context, ref content.Ref) ([]byte, error) {
+	f.hits.Add(1)
+	if data, ok := f.content[ref.Outpoint]; ok {
+		return data, nil
+	}
+	return nil, content.ErrNotFound
+}
+
+// embedRecord stores an embedded avatar for paymail at timestamp, with its
+// image served by resolver, and returns the record
+func embedRecord(t *testing.T, store storage.Store, resolver *fakeResolver, paymail string, timestamp int64, img []byte) *storage.AvatarData {
+	t.Helper()
+	sum := sha256.Sum256(img)
+	txid := hex.EncodeToString(sum[:]) // any distinct 64-hex id will do
+	data := &storage.AvatarData{
+		Outpoint:  txid + "_0",
+		TxID:      txid,
+		Paymail:   paymail,
+		Timestamp: timestamp,
+		ImageHash: hex.EncodeToString(sum[:]),
+		PubKey:    testPubKey,
+	}
+	if err := store.SetAvatar(data); err != nil {
+		t.Fatal(err)
+	}
+	resolver.content[data.Outpoint] = img
+	return data
+}
+
+// avatarApp routes the avatar endpoints to h
+func avatarApp(h *AvatarHandler) *fiber.App {
+	app := fiber.New()
+	app.Get("/u/:paymail", h.Handle)
+	app.Get("/pk/:pubkey", h.HandlePubKey)
+	app.Get("/c/:outpoint", h.HandleContent)
+	return app
+}
+
+// request sends method path with headers (name, value pairs) and returns the
+// response and its body
+func request(t *testing.T, app *fiber.App, method, path string, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
+
+func newTestAvatarHandler(store storage.Store, resolver content.Resolver) *AvatarHandler {
+	return NewAvatarHandler(store, resolver, nil, time.Hour, &diagnostics.Counters{})
+}
+
+func TestAvatarConditionalRequests(t *testing.T) {
+	store := storage.NewMemoryStore()
+	resolver := &fakeResolver{content: map[string][]byte{}}
+	img := testPNG(t, 4, 4, color.White)
+	rec := embedRecord(t, store, resolver, "alice@example.com", 1700000000, img)
+	app := avatarApp(newTestAvatarHandler(store, resolver))
+	etag := `"` + rec.Outpoint + `-0-orig"`
+	future := time.Unix(1800000000, 0).UTC().Format(http.TimeFormat)
+
+	for _, path := range []string{"/u/alice@example.com", "/pk/" + testPubKey} {
+		resp, body := request(t, app, http.MethodGet, path)
+		if resp.StatusCode != fiber.StatusOK || !bytes.Equal(body, img) {
+			t.Fatalf("GET %s = %d, %d bytes", path, resp.StatusCode, len(body))
+		}
+		if got := resp.Header.Get("ETag"); got != etag {
+			t.Errorf("GET %s ETag = %s, want %s", path, got, etag)
+		}
+		if got := resp.Header.Get("Last-Modified"); got != "" {
+			t.Errorf("GET %s has Last-Modified %s; mutable URLs validate by ETag only", path, got)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != mutableCacheControl {
+			t.Errorf("GET %s Cache-Control = %s", path, got)
+		}
+
+		if resp, _ := request(t, app, http.MethodGet, path, "If-None-Match", `"other", W/`+etag); resp.StatusCode != fiber.StatusNotModified {
+			t.Errorf("GET %s with a matching If-None-Match = %d, want 304", path, resp.StatusCode)
+		}
+		if resp, _ := request(t, app, http.MethodGet, path, "If-None-Match", `"other"`); resp.StatusCode != fiber.StatusOK {
+			t.Errorf("GET %s with a stale If-None-Match = %d, want 200", path, resp.StatusCode)
+		}
+		if resp, _ := request(t, app, http.MethodGet, path, "If-Modified-Since", future); resp.StatusCode != fiber.StatusOK {
+			t.Errorf("GET %s with If-Modified-Since = %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	path := "/c/" + rec.Outpoint
+	resp, _ := request(t, app, http.MethodGet, path)
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("ETag") != etag || resp.Header.Get("Cache-Control") != immutableCacheControl {
+		t.Fatalf("GET %s = %d, headers %v", path, resp.StatusCode, resp.Header)
+	}
+	if got := resp.Header.Get("Last-Modified"); got != time.Unix(1700000000, 0).UTC().Format(http.TimeFormat) {
+		t.Errorf("GET %s Last-Modified = %q", path, got)
+	}
+	if resp, _ := request(t, app, http.MethodGet, path, "If-Modified-Since", future); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("GET %s with a later If-Modified-Since = %d, want 304", path, resp.StatusCode)
+	}
+	if resp, _ := request(t, app, http.MethodGet, "/c/"+strings.Repeat("0", 64)+"_0"); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("GET unknown /c/ outpoint = %d, want 404", resp.StatusCode)
+	}
+}
+
+// When the newest record is removed (an ARC reject or a reorg), clients
+// holding its image must get the older one back, not a 304.
+func TestAvatarRevertedRecordNotCached(t *testing.T) {
+	store := storage.NewMemoryStore()
+	resolver := &fakeResolver{content: map[string][]byte{}}
+	older := testPNG(t, 4, 4, color.White)
+	newer := testPNG(t, 4, 4, color.Black)
+	embedRecord(t, store, resolver, "alice@example.com", 1700000000, older)
+	rec := embedRecord(t, store, resolver, "alice@example.com", 1700000600, newer)
+	app := avatarApp(newTestAvatarHandler(store, resolver))
+
+	resp, body := request(t, app, http.MethodGet, "/u/alice@example.com")
+	if !bytes.Equal(body, newer) {
+		t.Fatal("newest record not served")
+	}
+	etag := resp.Header.Get("ETag")
+
+	if _, err := store.RemoveAvatar("alice@example.com", rec.Outpoint); err != nil {
+		t.Fatal(err)
+	}
+	resp, body = request(t, app, http.MethodGet, "/u/alice@example.com",
+		"If-None-Match", etag,
+		"If-Modified-Since", time.Unix(1700000600, 0).UTC().Format(http.TimeFormat))
+	if resp.StatusCode != fiber.StatusOK || !bytes.Equal(body, older) {
+		t.Errorf("after removal = %d, %d bytes; want the older image", resp.StatusCode, len(body))
+	}
+	resp, body = request(t, app, http.MethodGet, "/u/alice@example.com",
+		"If-Modified-Since", time.Unix(1700000600, 0).UTC().Format(http.TimeFormat))
+	if resp.StatusCode != fiber.StatusOK || !bytes.Equal(body, older) {
+		t.Errorf("after removal, If-Modified-Since only = %d, %d bytes; want the older image", resp.StatusCode, len(body))
+	}
+}
+
+// HEAD answers with the status and headers of the GET, cached or not
+func TestAvatarHead(t *testing.T) {
+	png := testPNG(t, 4, 4, color.White)
+	tests := []struct {
+		name     string
+		img      []byte
+		served   []byte // what the resolver has instead of img, if set
+		query    string
+		wantCode int
+		wantETag string // suffix after the outpoint
+	}{
+		{name: "original", img: png, wantCode: fiber.StatusOK, wantETag: "-0-orig"},
+		{name: "variant", img: png, query: "?size=64&format=webp", wantCode: fiber.StatusOK, wantETag: "-64-webp"},
+		{name: "animation keeps its format", img: testGIF(t), query: "?size=64&format=webp", wantCode: fiber.StatusOK, wantETag: "-64-orig"},
+		{name: "content not found", img: png, served: []byte{}, wantCode: fiber.StatusNotFound},
+		{name: "hash mismatch", img: png, served: testPNG(t, 4, 4, color.Black), wantCode: fiber.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStore()
+			resolver := &fakeResolver{content: map[string][]byte{}}
+			rec := embedRecord(t, store, resolver, "alice@example.com", 1700000000, tt.img)
+			switch {
+			case tt.served == nil:
+			case len(tt.served) == 0:
+				delete(resolver.content, rec.Outpoint)
+			default:
+				resolver.content[rec.Outpoint] = tt.served
+			}
+			app := avatarApp(newTestAvatarHandler(store, resolver))
+			path := "/u/alice@example.com" + tt.query
+
+			head, body := request(t, app, http.MethodHead, path)
+			get, _ := request(t, app, http.MethodGet, path)
+			fetched := resolver.hits.Load()
+			cachedHead, _ := request(t, app, http.MethodHead, path)
+			if tt.wantCode == fiber.StatusOK && resolver.hits.Load() != fetched {
+				t.Error("HEAD of a cached image fetched content")
+			}
+			if len(body) != 0 {
+				t.Errorf("HEAD sent %d bytes", len(body))
+			}
+			if get.StatusCode != tt.wantCode {
+				t.Fatalf("GET = %d, want %d", get.StatusCode, tt.wantCode)
+			}
+			if tt.wantETag != "" && get.Header.Get("ETag") != `"`+rec.Outpoint+tt.wantETag+`"` {
+				t.Errorf("GET ETag = %s, want %s", get.Header.Get("ETag"), tt.wantETag)
+			}
+			for name, resp := range map[string]*http.Response{"HEAD": head, "cached HEAD": cachedHead} {
+				if resp.StatusCode != get.StatusCode {
+					t.Errorf("%s = %d, GET = %d", name, resp.StatusCode, get.StatusCode)
+				}
+				for _, header := range []string{"ETag", "Content-Type", "Cache-Control"} {
+					if got, want := resp.Header.Get(header), get.Header.Get(header); got != want {
+						t.Errorf("%s %s = %q, GET's = %q", name, header, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAvatarRedirect(t *testing.T) {
+	store := storage.NewMemoryStore()
+	resolver := &fakeResolver{content: map[string][]byte{}}
+	rec := embedRecord(t, store, resolver, "alice@example.com", 1700000000, testPNG(t, 4, 4, color.White))
+	h := newTestAvatarHandler(store, resolver)
+	h.SetRedirect(true)
+	app := avatarApp(h)
+
+	for _, path := range []string{"/u/alice@example.com", "/pk/" + testPubKey} {
+		resp, _ := request(t, app, http.MethodGet, path+"?size=64&format=png")
+		if resp.StatusCode != fiber.StatusFound {
+			t.Fatalf("GET %s = %d, want 302", path, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Location"), "/c/"+rec.Outpoint+"?size=64&format=png"; got != want {
+			t.Errorf("GET %s Location = %s, want %s", path, got, want)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != mutableCacheControl {
+			t.Errorf("GET %s Cache-Control = %s", path, got)
+		}
+	}
+
+	// The target is served, not redirected again
+	resp, _ := request(t, app, http.MethodGet, "/c/"+rec.Outpoint+"?size=64&format=png")
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("GET /c/ = %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if resp, _ := request(t, app, http.MethodGet, "/u/nobody@example.com"); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("GET unknown paymail = %d, want 404", resp.StatusCode)
+	}
+}
+
+// cacheCountingStore counts images written to the image cache
+type cacheCountingStore struct {
+	storage.Store
+	cached atomic.Int32
+}
+
+func (s *cacheCountingStore) CacheImage(outpoint string, data []byte, ttl time.Duration) error {
+	s.cached.Add(1)
+	return s.Store.CacheImage(outpoint, data, ttl)
+}
+
+func TestDefaultsNotCached(t *testing.T) {
+	store := &cacheCountingStore{Store: storage.NewMemoryStore()}
+	app := avatarApp(newTestAvatarHandler(store, &fakeResolver{content: map[string][]byte{}}))
+
+	for _, paymail := range []string{"nobody@example.com", "random-1@example.com", "random-2@example.com"} {
+		resp, body := request(t, app, http.MethodGet, "/u/"+paymail+"?d=identicon&size=64")
+		if resp.StatusCode != fiber.StatusOK || resp.Header.Get("Content-Type") != "image/png" || len(body) == 0 {
+			t.Fatalf("GET %s = %d, %s", paymail, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		etag := resp.Header.Get("ETag")
+		if resp, _ := request(t, app, http.MethodGet, "/u/"+paymail+"?d=identicon&size=64", "If-None-Match", etag); resp.StatusCode != fiber.StatusNotModified {
+			t.Errorf("GET %s revalidated = %d, want 304", paymail, resp.StatusCode)
+		}
+	}
+	if n := store.cached.Load(); n != 0 {
+		t.Errorf("%d generated defaults written to the image cache", n)
+	}
+}
@@ -1,50 +1,103 @@
 package handlers
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/bsvalias"
+	"github.com/b-open-io/bitpic/diagnostics"
+	"github.com/b-open-io/bitpic/headers"
 	"github.com/b-open-io/bitpic/storage"
+	"github.com/bsv-blockchain/go-sdk/spv"
 	"github.com/bsv-blockchain/go-sdk/transaction"
+	"github.com/bsv-blockchain/go-sdk/transaction/broadcaster"
 	"github.com/gofiber/fiber/v2"
 )
 
+// arcTimeout bounds a broadcast-mode round trip to ARC.
+const arcTimeout = 30 * time.Second
+
 // BroadcastHandler handles the /api/broadcast endpoint.
 //
-// The wallet broadcasts the BitPic transaction itself; this endpoint's job is
-// to index it immediately (parse + verify + store) so the avatar appears
-// without waiting for JungleBus to observe it on the network. JungleBus remains
-// the backstop for anything not posted here.
+// By default the wallet broadcasts the BitPic transaction itself; this
+// endpoint's job is to index it immediately (parse + verify + store) so the
+// avatar appears without waiting for JungleBus to observe it on the network.
+// JungleBus remains the backstop for anything not posted here.
+//
+// In broadcast mode ("broadcast": true) the handler submits the transaction to
+// ARC first, for clients that hold a signed tx but no broadcaster, and indexes
+// it only once ARC accepts it.
+//
+// In SPV mode (a header ChainTracker is configured) BEEF submissions must pass
+// SPV verification, and one whose subject tx carries a valid merkle proof is
+// indexed as confirmed straight away.
 type BroadcastHandler struct {
-	arcURL string
-	redis  *storage.RedisClient
+	arc     *broadcaster.Arc
+	headers headers.ChainTracker
+	store   storage.Store
+	blobs   storage.BlobStore
+	owners  *bsvalias.Verifier
+	rejects *diagnostics.RejectLog // optional log of outputs that failed validation
 }
 
 // BroadcastRequest is the request body. RawTx may be a bare transaction or
 // (atomic) BEEF — the wallet's sendBsv returns atomic BEEF.
 type BroadcastRequest struct {
-	RawTx string `json:"rawtx"`
+	RawTx     string `json:"rawtx"`
+	Broadcast bool   `json:"broadcast,omitempty"` // submit to ARC before indexing
 }
 
-// BroadcastResponse is the response.
+// BroadcastResponse is the response. TxStatus is ARC's status for the
+// transaction in broadcast mode. Outputs has a result for every BitPic output
+// of the transaction; a batch tx can set avatars for several paymails.
 type BroadcastResponse struct {
-	Success bool   `json:"success"`
-	TxID    string `json:"txid,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success  bool              `json:"success"`
+	TxID     string            `json:"txid,omitempty"`
+	TxStatus string            `json:"txStatus,omitempty"`
+	Verified bool              `json:"verified,omitempty"` // BEEF passed SPV verification
+	Outputs  []BroadcastOutput `json:"outputs,omitempty"`
+	Error    string            `json:"error,omitempty"`
 }
 
-// NewBroadcastHandler creates a new broadcast handler.
-func NewBroadcastHandler(arcURL string, redis *storage.RedisClient) *BroadcastHandler {
+// BroadcastOutput is the result for one BitPic output: indexed, or rejected
+// with a bitpic.Reason code.
+type BroadcastOutput struct {
+	Outpoint string `json:"outpoint"`
+	Paymail  string `json:"paymail,omitempty"`
+	Indexed  bool   `json:"indexed"`
+	Owner    string `json:"owner,omitempty"` // see bsvalias.OwnerVerified
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewBroadcastHandler creates a new broadcast handler. arc may be nil, which
+// disables broadcast mode; headers may be nil, which disables SPV mode; blobs
+// may be nil, in which case embedded images are not kept; owners may be nil,
+// in which case paymail ownership isn't checked.
+func NewBroadcastHandler(arc *broadcaster.Arc, headers headers.ChainTracker, store storage.Store, blobs storage.BlobStore, owners *bsvalias.Verifier) *BroadcastHandler {
 	return &BroadcastHandler{
-		arcURL: arcURL,
-		redis:  redis,
+		arc:     arc,
+		headers: headers,
+		store:   store,
+		blobs:   blobs,
+		owners:  owners,
 	}
 }
 
-// Handle parses, verifies, and stores a BitPic transaction immediately.
+// SetRejectLog makes the handler record BitPic outputs that fail validation
+// or the ownership policy into rejects, alongside the indexer's.
+func (h *BroadcastHandler) SetRejectLog(rejects *diagnostics.RejectLog) {
+	h.rejects = rejects
+}
+
+// Handle parses, verifies, and stores a BitPic transaction immediately,
+// broadcasting it through ARC first when asked to.
 func (h *BroadcastHandler) Handle(c *fiber.Ctx) error {
 	var req BroadcastRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -61,7 +114,7 @@ func (h *BroadcastHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	txBytes, err := extractTxBytes(req.RawTx)
+	tx, fromBEEF, err := parseSubmittedTx(req.RawTx)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(BroadcastResponse{
 			Success: false,
@@ -69,45 +122,270 @@ func (h *BroadcastHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	data, err := bitpic.ParseTransaction(txBytes)
+	// Verify before broadcasting: broadcast mode only relays BitPic txs.
+	results, err := bitpic.ParseTransactionAll(tx.Bytes())
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(BroadcastResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 	}
+	txid := tx.TxID().String()
+	outputs := make([]BroadcastOutput, len(results))
+	owners := make([]string, len(results))
+	var valid int
+	for i, r := range results {
+		outputs[i] = BroadcastOutput{Outpoint: r.Outpoint}
+		if r.Err != nil {
+			outputs[i].Reason = bitpic.Reason(r.Err)
+			outputs[i].Error = r.Err.Error()
+			detail := r.Err
+			var perr *bitpic.ParseError
+			if errors.As(r.Err, &perr) {
+				outputs[i].Paymail, detail = perr.Paymail, perr.Err
+			}
+			h.reject(txid, outputs[i].Paymail, outputs[i].Reason, detail)
+			continue
+		}
+		outputs[i].Paymail = r.Data.Paymail
+		if h.owners != nil {
+			owner, err := h.owners.Check(c.UserContext(), r.Data.Paymail, r.Data.PubKey)
+			outputs[i].Owner, owners[i] = owner, owner
+			if err != nil {
+				outputs[i].Reason = bsvalias.Reason
+				outputs[i].Error = err.Error()
+				h.reject(txid, r.Data.Paymail, bsvalias.Reason, err)
+				results[i].Data = nil
+				continue
+			}
+		}
+		valid++
+	}
+	if valid == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(BroadcastResponse{
+			Success: false,
+			TxID:    txid,
+			Outputs: outputs,
+			Error:   outputs[0].Error,
+		})
+	}
+
+	// Block the tx is already mined in, if known
+	var block *storage.BlockRef
+
+	var verified bool
+	if h.headers != nil && fromBEEF {
+		if block, err = h.verifySPV(c.UserContext(), tx); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(BroadcastResponse{
+				Success: false,
+				TxID:    txid,
+				Error:   err.Error(),
+			})
+		}
+		verified = true
+	}
+
+	var txStatus string
+	if req.Broadcast {
+		if h.arc == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(BroadcastResponse{
+				Success: false,
+				TxID:    txid,
+				Error:   "Broadcasting is not configured",
+			})
+		}
+		resp, status, err := h.submit(c.UserContext(), tx)
+		if err != nil {
+			log.Printf("ARC broadcast of %s failed: %v", txid, err)
+			return c.Status(status).JSON(BroadcastResponse{
+				Success:  false,
+				TxID:     txid,
+				TxStatus: arcTxStatus(resp),
+				Error:    err.Error(),
+			})
+		}
+		txStatus = arcTxStatus(resp)
+		if txStatus == string(broadcaster.MINED) && resp.BlockHash != "" {
+			block = &storage.BlockRef{Height: uint64(resp.BlockHeight), Hash: resp.BlockHash}
+		}
+	}
 
 	// Store immediately as unconfirmed (JungleBus upgrades it to confirmed and
 	// SetAvatar is newest-wins, so re-indexing is safe).
 	timestamp := time.Now().Unix()
-	if err := h.redis.SetAvatar(data.Paymail, data.Outpoint, data.TxID, timestamp, false, data.IsRef, data.RefOrigin); err != nil {
-		log.Printf("Failed to store avatar for %s: %v", data.Paymail, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(BroadcastResponse{
-			Success: false,
-			Error:   "failed to store avatar",
-		})
+	for i, r := range results {
+		if r.Data == nil {
+			continue
+		}
+		if err := h.index(r.Data, owners[i], timestamp, block); err != nil {
+			log.Printf("Failed to store avatar for %s: %v", r.Data.Paymail, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(BroadcastResponse{
+				Success: false,
+				TxID:    txid,
+				Error:   "failed to store avatar",
+			})
+		}
+		outputs[i].Indexed = true
+	}
+
+	return c.JSON(BroadcastResponse{Success: true, TxID: txid, TxStatus: txStatus, Verified: verified, Outputs: outputs})
+}
+
+// reject records a BitPic output that was refused in the reject log, if any
+func (h *BroadcastHandler) reject(txid, paymail, reason string, detail error) {
+	if h.rejects == nil {
+		return
+	}
+	h.rejects.Add(diagnostics.Reject{
+		TxID:    txid,
+		Paymail: paymail,
+		Reason:  reason,
+		Detail:  detail.Error(),
+		Source:  diagnostics.SourceBroadcast,
+	})
+}
+
+// index stores the avatar one BitPic output sets, confirmed in block if not nil.
+func (h *BroadcastHandler) index(data *bitpic.BitPicData, owner string, timestamp int64, block *storage.BlockRef) error {
+	avatar := &storage.AvatarData{
+		Outpoint:  data.Outpoint,
+		Timestamp: timestamp,
+		Paymail:   data.Paymail,
+		PubKey:    strings.ToLower(data.PubKey),
+		TxID:      data.TxID,
+		IsRef:     data.IsRef,
+		RefOrigin: data.RefOrigin,
+		RefURIs:   data.RefURIs,
+		ImageHash: data.ImageHash,
+		Owner:     owner,
+	}
+	if block != nil {
+		avatar.Confirmed = true
+		avatar.BlockHeight = block.Height
+		avatar.BlockHash = block.Hash
+	}
+
+	if h.blobs != nil && data.Image != nil {
+		if _, err := h.blobs.PutBlob(data.Image); err != nil {
+			log.Printf("Failed to store image for %s: %v", data.Outpoint, err)
+		}
+	}
+	if err := h.store.SetAvatar(avatar); err != nil {
+		return err
 	}
 
 	refInfo := ""
-	if data.IsRef {
+	switch {
+	case data.IsRef && data.RefOrigin == "":
+		refInfo = fmt.Sprintf(" (ref -> c://%s)", data.ImageHash)
+	case data.IsRef:
 		refInfo = fmt.Sprintf(" (ref -> %s)", data.RefOrigin)
 	}
-	log.Printf("Indexed BitPic avatar (unconfirmed): %s -> %s%s", data.Paymail, data.Outpoint, refInfo)
+	state := "unconfirmed"
+	if avatar.Confirmed {
+		state = "confirmed"
+	}
+	log.Printf("Indexed BitPic avatar (%s): %s -> %s%s", state, data.Paymail, data.Outpoint, refInfo)
+	return nil
+}
 
-	return c.JSON(BroadcastResponse{Success: true, TxID: data.TxID})
+// verifySPV checks a BEEF transaction's merkle proofs and ancestry against the
+// local headers. If the subject tx has a proof of its own it is already mined,
+// and the block it was mined in is returned.
+func (h *BroadcastHandler) verifySPV(ctx context.Context, tx *transaction.Transaction) (*storage.BlockRef, error) {
+	if ok, err := spv.Verify(ctx, tx, h.headers, nil); err != nil || !ok {
+		if err == nil {
+			err = errors.New("invalid")
+		}
+		return nil, fmt.Errorf("SPV verification failed: %w", err)
+	}
+
+	if tx.MerklePath == nil {
+		return nil, nil
+	}
+	height := tx.MerklePath.BlockHeight
+	hash, ok := h.headers.BlockHash(height)
+	if !ok {
+		return nil, fmt.Errorf("SPV verification failed: no header for block %d", height)
+	}
+	return &storage.BlockRef{Height: uint64(height), Hash: hash}, nil
+}
+
+// submit sends tx to ARC. A non-nil error means ARC did not accept the
+// transaction; the returned HTTP status tells the caller whether ARC rejected
+// it (400) or could not be reached (502).
+func (h *BroadcastHandler) submit(ctx context.Context, tx *transaction.Transaction) (*broadcaster.ArcResponse, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, arcTimeout)
+	defer cancel()
+
+	resp, err := h.arc.ArcBroadcast(ctx, tx)
+	if err != nil {
+		return nil, fiber.StatusBadGateway, fmt.Errorf("ARC broadcast failed: %w", err)
+	}
+	if resp.TxStatus == nil {
+		// ARC's error responses carry an HTTP-style status and no txStatus.
+		if resp.Status >= 500 || resp.Status == 0 {
+			return resp, fiber.StatusBadGateway, fmt.Errorf("ARC error: %s", arcReason(resp))
+		}
+		return resp, fiber.StatusBadRequest, fmt.Errorf("ARC rejected transaction: %s", arcReason(resp))
+	}
+	switch *resp.TxStatus {
+	case broadcaster.REJECTED, broadcaster.DOUBLE_SPEND_ATTEMPTED, broadcaster.SEEN_IN_ORPHAN_MEMPOOL:
+		return resp, fiber.StatusBadRequest, fmt.Errorf("ARC rejected transaction: %s", arcReason(resp))
+	}
+	return resp, fiber.StatusOK, nil
+}
+
+// arcTxStatus returns ARC's txStatus, or "" if it sent none.
+func arcTxStatus(resp *broadcaster.ArcResponse) string {
+	if resp == nil || resp.TxStatus == nil {
+		return ""
+	}
+	return string(*resp.TxStatus)
+}
+
+// arcReason picks the most specific explanation ARC gave.
+func arcReason(resp *broadcaster.ArcResponse) string {
+	switch {
+	case resp.ExtraInfo != "":
+		return resp.ExtraInfo
+	case resp.Detail != nil && *resp.Detail != "":
+		return *resp.Detail
+	case resp.Title != "":
+		return resp.Title
+	case resp.TxStatus != nil:
+		return string(*resp.TxStatus)
+	default:
+		return fmt.Sprintf("status %d", resp.Status)
+	}
 }
 
 // extractTxBytes returns the raw transaction bytes from either a bare tx hex or
 // (atomic) BEEF hex.
 func extractTxBytes(input string) ([]byte, error) {
+	tx, _, err := parseSubmittedTx(input)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Bytes(), nil
+}
+
+// parseSubmittedTx decodes a bare tx hex or (atomic) BEEF hex, reporting which
+// it was. A tx from BEEF keeps its source transactions and merkle proofs, so
+// it can be SPV-verified and ARC receives it in extended format.
+func parseSubmittedTx(input string) (*transaction.Transaction, bool, error) {
 	b, err := hex.DecodeString(input)
 	if err != nil {
-		return nil, fmt.Errorf("invalid transaction hex: %w", err)
+		return nil, false, fmt.Errorf("invalid transaction hex: %w", err)
 	}
 	// The wallet's sendBsv returns atomic BEEF; extract the subject tx.
 	if tx, err := transaction.NewTransactionFromBEEF(b); err == nil && tx != nil {
-		return tx.Bytes(), nil
+		return tx, true, nil
 	}
 	// Otherwise assume a bare raw transaction.
-	return b, nil
+	tx, err := transaction.NewTransactionFromBytes(b)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid transaction: %w", err)
+	}
+	return tx, false, nil
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/diagnostics"
+	"github.com/b-open-io/bitpic/storage"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+	"github.com/bsv-blockchain/go-sdk/transaction/broadcaster"
+	"github.com/gofiber/fiber/v2"
+)
+
+// testAvatarTx returns a transaction setting paymail's avatar to image
+func testAvatarTx(t *testing.T, paymail string, image []byte) *transaction.Transaction {
+	t.Helper()
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := bitpic.Embed(image, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := bitpic.NewOutput(content, paymail, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTransaction()
+	tx.AddOutput(out)
+	return tx
+}
+
+// postBroadcast posts tx to /api/broadcast and decodes the response
+func postBroadcast(t *testing.T, h *BroadcastHandler, tx *transaction.Transaction, broadcast bool) (int, BroadcastResponse) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/broadcast", h.Handle)
+
+	body, _ := json.Marshal(BroadcastRequest{RawTx: hex.EncodeToString(tx.Bytes()), Broadcast: broadcast})
+	req := httptest.NewRequest(http.MethodPost, "/api/broadcast", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var out BroadcastResponse
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("bad response %q: %v", data, err)
+	}
+	return resp.StatusCode, out
+}
+
+// fakeARC serves POST /v1/tx with respond
+func fakeARC(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) *broadcaster.Arc {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/tx" {
+			http.NotFound(w, r)
+			return
+		}
+		respond(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return &broadcaster.Arc{ApiUrl: srv.URL + "/v1", ApiKey: "test-key", Client: &http.Client{Timeout: time.Second}}
+}
+
+func TestBroadcastARC(t *testing.T) {
+	tests := []struct {
+		name       string
+		respond    func(w http.ResponseWriter, r *http.Request)
+		clientWait time.Duration // client timeout, if not the default
+		wantCode   int
+		wantStatus string
+		indexed    bool
+		confirmed  bool
+	}{
+		{
+			name: "accepted",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer test-key" {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"status":401,"title":"Unauthorized"}`)
+					return
+				}
+				fmt.Fprint(w, `{"txStatus":"SEEN_ON_NETWORK","status":200}`)
+			},
+			wantCode:   fiber.StatusOK,
+			wantStatus: "SEEN_ON_NETWORK",
+			indexed:    true,
+		},
+		{
+			name: "mined",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"txStatus":"MINED","status":200,"blockHeight":800000,"blockHash":"00000000000000000abc"}`)
+			},
+			wantCode:   fiber.StatusOK,
+			wantStatus: "MINED",
+			indexed:    true,
+			confirmed:  true,
+		},
+		{
+			name: "rejected",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"txStatus":"REJECTED","status":200,"extraInfo":"missing inputs"}`)
+			},
+			wantCode:   fiber.StatusBadRequest,
+			wantStatus: "REJECTED",
+		},
+		{
+			name: "error response",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(465)
+				fmt.Fprint(w, `{"status":465,"title":"Fee too low"}`)
+			},
+			wantCode: fiber.StatusBadRequest,
+		},
+		{
+			name: "server error",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `{"status":503,"title":"Unavailable"}`)
+			},
+			wantCode: fiber.StatusBadGateway,
+		},
+		{
+			name: "timeout",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(500 * time.Millisecond)
+				fmt.Fprint(w, `{"txStatus":"SEEN_ON_NETWORK","status":200}`)
+			},
+			clientWait: 50 * time.Millisecond,
+			wantCode:   fiber.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arc := fakeARC(t, tt.respond)
+			if tt.clientWait > 0 {
+				arc.Client = &http.Client{Timeout: tt.clientWait}
+			}
+			store := storage.NewMemoryStore()
+			h := NewBroadcastHandler(arc, nil, store, nil, nil)
+			tx := testAvatarTx(t, "alice@example.com", []byte("\x89PNG\r\n\x1a\nimage"))
+
+			code, resp := postBroadcast(t, h, tx, true)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%+v)", code, tt.wantCode, resp)
+			}
+			if resp.Success != (tt.wantCode == fiber.StatusOK) || resp.TxID != tx.TxID().String() {
+				t.Errorf("response = %+v", resp)
+			}
+			if resp.TxStatus != tt.wantStatus {
+				t.Errorf("txStatus = %q, want %q", resp.TxStatus, tt.wantStatus)
+			}
+
+			data, err := store.GetAvatarData("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (data != nil) != tt.indexed {
+				t.Fatalf("indexed = %v, want %v", data != nil, tt.indexed)
+			}
+			if data != nil && data.Confirmed != tt.confirmed {
+				t.Errorf("confirmed = %v, want %v", data.Confirmed, tt.confirmed)
+			}
+			if tt.confirmed && (data.BlockHeight != 800000 || data.BlockHash != "00000000000000000abc") {
+				t.Errorf("block = %d %s, want ARC's", data.BlockHeight, data.BlockHash)
+			}
+		})
+	}
+}
+
+func TestBroadcastWithoutARC(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewBroadcastHandler(nil, nil, store, nil, nil)
+	tx := testAvatarTx(t, "bob@example.com", []byte("\x89PNG\r\n\x1a\nimage"))
+
+	if code, _ := postBroadcast(t, h, tx, true); code != fiber.StatusServiceUnavailable {
+		t.Errorf("broadcast mode without ARC: status = %d, want 503", code)
+	}
+	if code, resp := postBroadcast(t, h, tx, false); code != fiber.StatusOK || !resp.Success || len(resp.Outputs) != 1 || !resp.Outputs[0].Indexed {
+		t.Errorf("index only: status = %d, response = %+v", code, resp)
+	}
+}
+
+func TestBroadcastRecordsRejects(t *testing.T) {
+	store := storage.NewMemoryStore()
+	rejects := diagnostics.NewRejectLog(10)
+	h := NewBroadcastHandler(nil, nil, store, nil, nil)
+	h.SetRejectLog(rejects)
+
+	// Swap the image for one the signature doesn't cover
+	tx := testAvatarTx(t, "carol@example.com", []byte("\x89PNG\r\n\x1a\nimageA"))
+	s := tx.Outputs[0].LockingScript
+	*s = bytes.Replace(*s, []byte("imageA"), []byte("imageB"), 1)
+
+	code, resp := postBroadcast(t, h, tx, false)
+	if code != fiber.StatusBadRequest || resp.Success || len(resp.Outputs) != 1 || resp.Outputs[0].Reason != "bad_signature" {
+		t.Fatalf("status = %d, response = %+v; want a bad_signature reject", code, resp)
+	}
+
+	got := rejects.Recent("carol@example.com", 0)
+	if len(got) != 1 {
+		t.Fatalf("reject log has %d entries for the paymail, want 1", len(got))
+	}
+	if r := got[0]; r.TxID != tx.TxID().String() || r.Reason != "bad_signature" || r.Source != diagnostics.SourceBroadcast || r.Detail == "" {
+		t.Errorf("reject = %+v", r)
+	}
+}
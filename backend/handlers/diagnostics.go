@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/b-open-io/bitpic/content"
+	"github.com/b-open-io/bitpic/diagnostics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DiagnosticsHandler handles the /api/diagnostics endpoints
+type DiagnosticsHandler struct {
+	rejects  *diagnostics.RejectLog
+	counters *diagnostics.Counters
+	resolver *content.Chain
+}
+
+// RejectsResponse lists recently rejected BitPic transactions
+type RejectsResponse struct {
+	Items []diagnostics.Reject `json:"items"`
+}
+
+// NewDiagnosticsHandler creates a new diagnostics handler
+func NewDiagnosticsHandler(rejects *diagnostics.RejectLog, counters *diagnostics.Counters, resolver *content.Chain) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		rejects:  rejects,
+		counters: counters,
+		resolver: resolver,
+	}
+}
+
+// Rejects returns recent transactions that carried a BitPic tape but failed
+// validation, newest first, optionally filtered by ?paymail=
+func (h *DiagnosticsHandler) Rejects(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit < 1 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	return c.JSON(RejectsResponse{
+		Items: h.rejects.Recent(c.Query("paymail"), limit),
+	})
+}
+
+// Counters returns the diagnostic counters
+func (h *DiagnosticsHandler) Counters(c *fiber.Ctx) error {
+	return c.JSON(h.counters.Snapshot())
+}
+
+// Resolvers returns the health of each avatar content resolver, in the order
+// they are tried
+func (h *DiagnosticsHandler) Resolvers(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"resolvers": h.resolver.Health()})
+}
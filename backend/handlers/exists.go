@@ -9,13 +9,13 @@ import (
 
 // ExistsHandler handles the /api/exists/:paymail endpoint
 type ExistsHandler struct {
-	redis *storage.RedisClient
+	store storage.Store
 }
 
 // NewExistsHandler creates a new exists handler
-func NewExistsHandler(redis *storage.RedisClient) *ExistsHandler {
+func NewExistsHandler(store storage.Store) *ExistsHandler {
 	return &ExistsHandler{
-		redis: redis,
+		store: store,
 	}
 }
 
@@ -26,7 +26,7 @@ func (h *ExistsHandler) Handle(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("0")
 	}
 
-	exists, err := h.redis.Exists(paymail)
+	exists, err := h.store.Exists(paymail)
 	if err != nil {
 		log.Printf("Exists lookup failed: paymail=%s error=%v", paymail, err)
 		return c.Status(fiber.StatusInternalServerError).SendString("0")
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/b-open-io/bitpic/content"
+	"github.com/gofiber/fiber/v2"
+)
+
+// What d=<url> does, by DEFAULT_URL_MODE
+const (
+	FallbackOff      = "off"      // URLs are ignored, as if d were unset
+	FallbackRedirect = "redirect" // redirect to the URL
+	FallbackProxy    = "proxy"    // fetch the URL and serve it like an avatar
+)
+
+// fallbackCacheTTL is how long a proxied default is cached: the image at a
+// URL may change, unlike an avatar's
+const fallbackCacheTTL = time.Hour
+
+// maxFallbackRedirects caps the redirects followed when proxying a default
+const maxFallbackRedirects = 3
+
+// FallbackPolicy decides which URLs d= may name and what is done with them.
+// Only https URLs on an allowed host are used, so /u/ can't redirect to (or,
+// proxying, fetch) anywhere else.
+type FallbackPolicy struct {
+	proxy    bool
+	hosts    map[string]bool // exact host names
+	suffixes []string        // ".example.com" for *.example.com
+	client   *http.Client
+	maxBytes int64
+}
+
+// NewFallbackPolicy returns the policy for mode (off, redirect or proxy)
+// allowing hosts, which may start with "*." to allow subdomains. Off returns
+// nil. Proxied images are fetched within timeout and maxBytes.
+func NewFallbackPolicy(mode string, hosts []string, timeout time.Duration, maxBytes int64) (*FallbackPolicy, error) {
+	switch mode {
+	case "", FallbackOff:
+		return nil, nil
+	case FallbackRedirect, FallbackProxy:
+	default:
+		return nil, fmt.Errorf("unknown fallback mode %q (want off, redirect or proxy)", mode)
+	}
+
+	p := &FallbackPolicy{
+		proxy:    mode == FallbackProxy,
+		hosts:    make(map[string]bool),
+		maxBytes: maxBytes,
+	}
+	for _, host := range hosts {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		switch {
+		case host == "":
+		case strings.HasPrefix(host, "*."):
+			p.suffixes = append(p.suffixes, host[1:])
+		default:
+			p.hosts[host] = true
+		}
+	}
+	if len(p.hosts) == 0 && len(p.suffixes) == 0 {
+		return nil, fmt.Errorf("fallback mode %s needs at least one allowed host", mode)
+	}
+
+	// Redirects are followed only to allowed URLs
+	p.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFallbackRedirects {
+				return errors.New("too many redirects")
+			}
+			if _, ok := p.allow(req.URL.String()); !ok {
+				return fmt.Errorf("redirect to %s not allowed", req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+	return p, nil
+}
+
+// allow parses raw and reports whether d= may name it: an https URL without
+// credentials on an allowed host. A nil policy allows nothing.
+func (p *FallbackPolicy) allow(raw string) (*url.URL, bool) {
+	if p == nil {
+		return nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Opaque != "" || u.User != nil {
+		return nil, false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return nil, false
+	}
+	if p.hosts[host] {
+		return u, true
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// serveProxied responds with the image at u in place of an avatar, checked,
+// resized and converted like one and cached for fallbackCacheTTL
+func (h *AvatarHandler) serveProxied(c *fiber.Ctx, fb fallback, u *url.URL) error {
+	variant := fb.variant
+	if variant == formatSource {
+		variant = "orig"
+	}
+	sum := sha256.Sum256([]byte(u.String()))
+	key := fmt.Sprintf("default_url_%x_%d_%s", sum[:16], fb.size, variant)
+	c.Set("Cache-Control", mutableCacheControl)
+
+	if cached, err := h.store.GetCachedImage(key); err == nil && cached != nil {
+		if contentType := detectContentType(cached); isAllowedContentType(contentType) {
+			return sendImage(c, cached, contentType)
+		}
+	}
+
+	data, err := content.Fetch(c.UserContext(), h.fallbacks.client, u.String(), h.fallbacks.maxBytes)
+	switch {
+	case errors.Is(err, content.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).SendString("Default image not found")
+	case errors.Is(err, content.ErrTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).SendString("Default image too large")
+	case err != nil:
+		log.Printf("Failed to fetch default image %s: %v", u.Redacted(), err)
+		return c.Status(fiber.StatusBadGateway).SendString("Failed to fetch default image")
+	}
+
+	contentType := detectContentType(data)
+	if contentType == "" || !isAllowedContentType(contentType) {
+		return c.Status(fiber.StatusUnsupportedMediaType).SendString("Unsupported image format")
+	}
+
+	if fb.size > 0 || fb.variant != formatSource {
+		resized, resizedType, err := resizeImage(data, fb.size, outputType(fb.variant, contentType))
+		if err != nil {
+			log.Printf("Failed to resize default image: %v", err)
+			// Fall back to the image as fetched
+		} else {
+			data, contentType = resized, resizedType
+		}
+	}
+
+	if err := h.store.CacheImage(key, data, fallbackCacheTTL); err != nil {
+		log.Printf("Failed to cache default image: %v", err)
+	}
+	return sendImage(c, data, contentType)
+}
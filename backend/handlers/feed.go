@@ -11,7 +11,7 @@ import (
 
 // FeedHandler handles the /api/feed endpoint
 type FeedHandler struct {
-	redis        *storage.RedisClient
+	store        storage.Store
 	ordfsBaseURL string
 }
 
@@ -24,13 +24,13 @@ type FeedResponse struct {
 }
 
 // NewFeedHandler creates a new feed handler
-func NewFeedHandler(redis *storage.RedisClient) *FeedHandler {
+func NewFeedHandler(store storage.Store) *FeedHandler {
 	ordfsBaseURL := os.Getenv("ORDFS_BASE_URL")
 	if ordfsBaseURL == "" {
 		ordfsBaseURL = "https://ordfs.network"
 	}
 	return &FeedHandler{
-		redis:        redis,
+		store:        store,
 		ordfsBaseURL: ordfsBaseURL,
 	}
 }
@@ -57,7 +57,7 @@ func (h *FeedHandler) Handle(c *fiber.Ctx) error {
 	}
 
 	// Get feed items
-	items, total, err := h.redis.GetFeed(offset, limit, h.ordfsBaseURL)
+	items, total, err := h.store.GetFeed(offset, limit, h.ordfsBaseURL)
 	if err != nil {
 		log.Printf("Feed lookup failed: offset=%d limit=%d error=%v", offset, limit, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
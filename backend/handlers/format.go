@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/gofiber/fiber/v2"
+	_ "golang.org/x/image/webp" // WebP decoder for image.Decode
+)
+
+// Output format variants of an avatar image. Each is cached separately.
+//
+// AVIF is not offered: there is no practical pure-Go encoder, so format=avif
+// is negotiated like a request without a format.
+const (
+	formatSource = ""     // the original's format; PNG when resizing a WebP original
+	formatAuto   = "auto" // the client accepts WebP: WebP unless the original is a JPEG
+	formatPNG    = "png"
+	formatJPEG   = "jpeg"
+	formatGIF    = "gif"
+	formatWebP   = "webp"
+)
+
+// negotiateFormat picks the format variant to serve from the format query
+// parameter or, for resized images, the Accept header. negotiated reports
+// whether the choice depends on Accept.
+func negotiateFormat(c *fiber.Ctx, size int) (variant string, negotiated bool) {
+	switch format := strings.ToLower(c.Query("format")); format {
+	case formatPNG, formatGIF, formatWebP, formatJPEG:
+		return format, false
+	case "jpg":
+		return formatJPEG, false
+	}
+
+	// Originals are served as signed unless a format is asked for
+	if size == 0 {
+		return formatSource, false
+	}
+	if acceptsWebP(c.Get("Accept")) {
+		return formatAuto, true
+	}
+	return formatSource, true
+}
+
+// acceptsWebP reports whether an Accept header lists image/webp explicitly
+// with a non-zero quality. Wildcards don't count: clients that send only
+// */* may not decode WebP.
+func acceptsWebP(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "image/webp") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// outputType returns the content type a variant of an image of sourceType is
+// encoded as
+func outputType(variant, sourceType string) string {
+	switch variant {
+	case formatPNG:
+		return "image/png"
+	case formatJPEG:
+		return "image/jpeg"
+	case formatGIF:
+		return "image/gif"
+	case formatWebP:
+		return "image/webp"
+	case formatAuto:
+		// Lossless WebP beats PNG and GIF but not a lossy JPEG
+		if sourceType == "image/jpeg" {
+			return sourceType
+		}
+		return "image/webp"
+	}
+	if sourceType == "image/webp" {
+		return "image/png"
+	}
+	return sourceType
+}
+
+// encodeImage encodes img as contentType. WebP output is lossless.
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	case "image/webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("cannot encode %s", contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -13,15 +13,15 @@ const feeTolerance = 1000
 
 // PaymailHandler handles paymail-related endpoints
 type PaymailHandler struct {
-	redis      *storage.RedisClient
+	store      storage.Store
 	feeAddress string
 }
 
 // NewPaymailHandler creates a new paymail handler. feeAddress is the address
 // that must receive the registration fee.
-func NewPaymailHandler(redis *storage.RedisClient, feeAddress string) *PaymailHandler {
+func NewPaymailHandler(store storage.Store, feeAddress string) *PaymailHandler {
 	return &PaymailHandler{
-		redis:      redis,
+		store:      store,
 		feeAddress: feeAddress,
 	}
 }
@@ -35,7 +35,7 @@ func (h *PaymailHandler) Get(c *fiber.Ctx) error {
 		})
 	}
 
-	paymail, err := h.redis.GetPaymail(handle)
+	paymail, err := h.store.GetPaymail(handle)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch paymail",
@@ -122,7 +122,7 @@ func (h *PaymailHandler) Register(c *fiber.Ctx) error {
 	}
 
 	// Check if handle already exists
-	existing, _ := h.redis.GetPaymail(req.Handle)
+	existing, _ := h.store.GetPaymail(req.Handle)
 	if existing != nil {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 			"error": "Handle already taken",
@@ -130,7 +130,7 @@ func (h *PaymailHandler) Register(c *fiber.Ctx) error {
 	}
 
 	// Claim the fee txid so one payment can't register multiple handles.
-	fresh, err := h.redis.ClaimPaymentTxid(payment.TxID)
+	fresh, err := h.store.ClaimPaymentTxid(payment.TxID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to register paymail",
@@ -150,7 +150,7 @@ func (h *PaymailHandler) Register(c *fiber.Ctx) error {
 		OrdAddress:     req.OrdAddress,
 		PaymentTxid:    payment.TxID,
 	}
-	if err := h.redis.SetPaymail(data); err != nil {
+	if err := h.store.SetPaymail(data); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to register paymail",
 		})
@@ -171,7 +171,7 @@ func (h *PaymailHandler) CheckAvailable(c *fiber.Ctx) error {
 		})
 	}
 
-	existing, _ := h.redis.GetPaymail(handle)
+	existing, _ := h.store.GetPaymail(handle)
 	available := existing == nil
 
 	return c.JSON(fiber.Map{
@@ -189,7 +189,7 @@ func (h *PaymailHandler) GetByPubkey(c *fiber.Ctx) error {
 		})
 	}
 
-	paymail, err := h.redis.GetPaymailByPubkey(pubkey)
+	paymail, err := h.store.GetPaymailByPubkey(pubkey)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to lookup paymail",
@@ -11,7 +11,7 @@ import (
 
 // StatusHandler handles the /api/status endpoint
 type StatusHandler struct {
-	redis      *storage.RedisClient
+	store      storage.Store
 	subscriber *junglebus.Subscriber
 }
 
@@ -25,9 +25,9 @@ type StatusResponse struct {
 }
 
 // NewStatusHandler creates a new status handler
-func NewStatusHandler(redis *storage.RedisClient, subscriber *junglebus.Subscriber) *StatusHandler {
+func NewStatusHandler(store storage.Store, subscriber *junglebus.Subscriber) *StatusHandler {
 	return &StatusHandler{
-		redis:      redis,
+		store:      store,
 		subscriber: subscriber,
 	}
 }
@@ -35,7 +35,7 @@ func NewStatusHandler(redis *storage.RedisClient, subscriber *junglebus.Subscrib
 // Handle returns the current system status
 func (h *StatusHandler) Handle(c *fiber.Ctx) error {
 	// Get total avatars
-	totalAvatars, err := h.redis.GetTotalAvatars()
+	totalAvatars, err := h.store.GetTotalAvatars()
 	if err != nil {
 		log.Printf("Status lookup failed: error=%v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ValidateHandler handles the /api/validate endpoint: a dry run of
+// /api/broadcast that reports what a transaction would index, without
+// storing or broadcasting anything.
+type ValidateHandler struct {
+	store storage.Store
+}
+
+// ValidateRequest is the request body. RawTx may be a bare transaction or
+// BEEF; Paymail, if set, is the paymail the avatar is expected to be for.
+type ValidateRequest struct {
+	RawTx   string `json:"rawtx"`
+	Paymail string `json:"paymail,omitempty"`
+}
+
+// ValidateResponse describes the avatar record a transaction carries. When
+// the transaction is rejected, Error says why and the record fields hold
+// whatever could be read.
+type ValidateResponse struct {
+	Valid     bool     `json:"valid"`
+	TxID      string   `json:"txid,omitempty"`
+	Outpoint  string   `json:"outpoint,omitempty"`
+	Paymail   string   `json:"paymail,omitempty"`
+	PubKey    string   `json:"pubkey,omitempty"`
+	Kind      string   `json:"kind,omitempty"` // "embed" or "ref"
+	MediaType string   `json:"mediaType,omitempty"`
+	ImageSize int      `json:"imageSize,omitempty"`
+	ImageHash string   `json:"imageHash,omitempty"`
+	RefOrigin string   `json:"refOrigin,omitempty"`
+	RefURIs   []string `json:"refUris,omitempty"`
+
+	// Whether indexing it now would replace the paymail's current avatar
+	// (newest-wins), and that avatar.
+	WouldWin bool                `json:"wouldWin"`
+	Current  *storage.AvatarData `json:"current,omitempty"`
+
+	Error *ValidateError `json:"error,omitempty"`
+}
+
+// ValidateError is a rejection reason. Reason is a bitpic.Reason code, or
+// paymail_mismatch when the record is valid but for another paymail.
+type ValidateError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// NewValidateHandler creates a new validate handler
+func NewValidateHandler(store storage.Store) *ValidateHandler {
+	return &ValidateHandler{
+		store: store,
+	}
+}
+
+// Handle parses and verifies a BitPic transaction and reports whether it would
+// become the paymail's avatar. Rejected transactions get a 400 with a
+// structured error.
+func (h *ValidateHandler) Handle(c *fiber.Ctx) error {
+	var req ValidateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.RawTx == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Raw transaction is required",
+		})
+	}
+
+	tx, _, err := parseSubmittedTx(req.RawTx)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ValidateResponse{
+			Error: &ValidateError{Reason: bitpic.Reason(err), Message: err.Error()},
+		})
+	}
+
+	data, err := parseFor(tx.Bytes(), req.Paymail)
+	if err != nil {
+		resp := ValidateResponse{
+			TxID:  tx.TxID().String(),
+			Error: &ValidateError{Reason: bitpic.Reason(err), Message: err.Error()},
+		}
+		var perr *bitpic.ParseError
+		if errors.As(err, &perr) {
+			resp.Paymail = perr.Paymail
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	resp := ValidateResponse{
+		Valid:     true,
+		TxID:      data.TxID,
+		Outpoint:  data.Outpoint,
+		Paymail:   data.Paymail,
+		PubKey:    data.PubKey,
+		Kind:      "embed",
+		MediaType: data.MediaType,
+		ImageSize: data.ImageSize,
+		ImageHash: data.ImageHash,
+		RefOrigin: data.RefOrigin,
+		RefURIs:   data.RefURIs,
+	}
+	if data.IsRef {
+		resp.Kind = "ref"
+	}
+
+	current, err := h.store.GetAvatarData(data.Paymail)
+	if err != nil {
+		log.Printf("Validate: failed to look up %s: %v", data.Paymail, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch current avatar",
+		})
+	}
+	resp.Current = current
+	// /api/broadcast stamps records with the time they are indexed.
+	resp.WouldWin = storage.NewestWins(current, data.TxID, time.Now().Unix())
+
+	if req.Paymail != "" && !strings.EqualFold(req.Paymail, data.Paymail) {
+		resp.Valid = false
+		resp.WouldWin = false
+		resp.Error = &ValidateError{
+			Reason:  "paymail_mismatch",
+			Message: "record is for " + data.Paymail + ", not " + req.Paymail,
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	return c.JSON(resp)
+}
+
+// parseFor parses a BitPic transaction, picking paymail's output of a batch
+// tx (a valid one first) when paymail is given and has one.
+func parseFor(raw []byte, paymail string) (*bitpic.BitPicData, error) {
+	results, err := bitpic.ParseTransactionAll(raw)
+	if err != nil || paymail == "" {
+		return bitpic.ParseTransaction(raw)
+	}
+	var rejected error
+	for _, r := range results {
+		var perr *bitpic.ParseError
+		switch {
+		case r.Data != nil && strings.EqualFold(r.Data.Paymail, paymail):
+			return r.Data, nil
+		case rejected == nil && errors.As(r.Err, &perr) && strings.EqualFold(perr.Paymail, paymail):
+			rejected = r.Err
+		}
+	}
+	if rejected != nil {
+		return nil, rejected
+	}
+	return bitpic.ParseTransaction(raw)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/storage"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+	"github.com/gofiber/fiber/v2"
+)
+
+// refAvatarTx returns a transaction pointing paymail's avatar at uris
+func refAvatarTx(t *testing.T, paymail string, uris ...string) *transaction.Transaction {
+	t.Helper()
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := bitpic.References(uris...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := bitpic.NewOutput(content, paymail, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTransaction()
+	tx.AddOutput(out)
+	return tx
+}
+
+// postValidate posts req to /api/validate and decodes the response
+func postValidate(t *testing.T, h *ValidateHandler, req ValidateRequest) (int, ValidateResponse) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/validate", h.Handle)
+
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/api/validate", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(r, 5000)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var out ValidateResponse
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("bad response %q: %v", data, err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestValidateEmbed(t *testing.T) {
+	img := testPNG(t, 4, 4, color.White)
+	tx := testAvatarTx(t, "alice@example.com", img)
+	data, err := bitpic.ParseTransaction(tx.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	beef, err := tx.BEEF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string][]byte{"raw": tx.Bytes(), "beef": beef} {
+		t.Run(name, func(t *testing.T) {
+			code, resp := postValidate(t, NewValidateHandler(storage.NewMemoryStore()),
+				ValidateRequest{RawTx: hex.EncodeToString(raw), Paymail: "Alice@Example.com"})
+			if code != fiber.StatusOK || !resp.Valid || resp.Error != nil {
+				t.Fatalf("status = %d, valid = %v, error = %+v", code, resp.Valid, resp.Error)
+			}
+			want := ValidateResponse{
+				Valid:     true,
+				TxID:      tx.TxID().String(),
+				Outpoint:  tx.TxID().String() + "_0",
+				Paymail:   "alice@example.com",
+				PubKey:    data.PubKey,
+				Kind:      "embed",
+				MediaType: "image/png",
+				ImageSize: len(img),
+				ImageHash: data.ImageHash,
+				WouldWin:  true,
+			}
+			if !reflect.DeepEqual(resp, want) {
+				t.Errorf("response = %+v\nwant %+v", resp, want)
+			}
+		})
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	ord := "ord://" + strings.Repeat("1", 64) + "_0"
+	b := "b://" + strings.Repeat("2", 64) + "_1"
+	tx := refAvatarTx(t, "alice@example.com", ord, b)
+
+	code, resp := postValidate(t, NewValidateHandler(storage.NewMemoryStore()),
+		ValidateRequest{RawTx: hex.EncodeToString(tx.Bytes())})
+	if code != fiber.StatusOK || !resp.Valid {
+		t.Fatalf("status = %d, valid = %v, error = %+v", code, resp.Valid, resp.Error)
+	}
+	if resp.Kind != "ref" || resp.MediaType != bitpic.UriListMime {
+		t.Errorf("kind, mediaType = %q, %q; want ref, %s", resp.Kind, resp.MediaType, bitpic.UriListMime)
+	}
+	if resp.RefOrigin != strings.Repeat("1", 64)+"_0" || !reflect.DeepEqual(resp.RefURIs, []string{ord, b}) {
+		t.Errorf("refOrigin, refUris = %q, %v", resp.RefOrigin, resp.RefURIs)
+	}
+	if resp.ImageSize != 0 || resp.ImageHash != "" {
+		t.Errorf("imageSize, imageHash = %d, %q; want none for an outpoint ref", resp.ImageSize, resp.ImageHash)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	tx := testAvatarTx(t, "alice@example.com", testPNG(t, 4, 4, color.White))
+	notBitPic := transaction.NewTransaction()
+	s := &script.Script{}
+	_ = s.AppendOpcodes(script.OpFALSE, script.OpRETURN)
+	_ = s.AppendPushData([]byte("hello"))
+	notBitPic.AddOutput(&transaction.TransactionOutput{LockingScript: s})
+
+	tests := []struct {
+		name        string
+		req         ValidateRequest
+		wantReason  string
+		wantPaymail string
+	}{
+		{
+			name:       "malformed hex",
+			req:        ValidateRequest{RawTx: "not hex"},
+			wantReason: "invalid_tx",
+		},
+		{
+			name:       "malformed BEEF",
+			req:        ValidateRequest{RawTx: "0100beef" + "ff00"},
+			wantReason: "invalid_tx",
+		},
+		{
+			name:       "not BitPic",
+			req:        ValidateRequest{RawTx: hex.EncodeToString(notBitPic.Bytes())},
+			wantReason: "not_bitpic",
+		},
+		{
+			name:        "paymail mismatch",
+			req:         ValidateRequest{RawTx: hex.EncodeToString(tx.Bytes()), Paymail: "bob@example.com"},
+			wantReason:  "paymail_mismatch",
+			wantPaymail: "alice@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := postValidate(t, NewValidateHandler(storage.NewMemoryStore()), tt.req)
+			if code != fiber.StatusBadRequest || resp.Valid || resp.WouldWin {
+				t.Errorf("status = %d, valid = %v, wouldWin = %v; want 400, false, false", code, resp.Valid, resp.WouldWin)
+			}
+			if resp.Error == nil || resp.Error.Reason != tt.wantReason || resp.Error.Message == "" {
+				t.Fatalf("error = %+v, want reason %s", resp.Error, tt.wantReason)
+			}
+			if resp.Paymail != tt.wantPaymail {
+				t.Errorf("paymail = %q, want %q", resp.Paymail, tt.wantPaymail)
+			}
+		})
+	}
+}
+
+func TestValidateWouldWin(t *testing.T) {
+	tx := testAvatarTx(t, "alice@example.com", testPNG(t, 4, 4, color.White))
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		want      bool
+	}{
+		{"older current avatar", 100, true},
+		{"newer current avatar", time.Now().Add(time.Hour).Unix(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStore()
+			current := &storage.AvatarData{Paymail: "alice@example.com", TxID: strings.Repeat("c", 64),
+				Outpoint: strings.Repeat("c", 64) + "_0", Timestamp: tt.timestamp}
+			if err := store.SetAvatar(current); err != nil {
+				t.Fatal(err)
+			}
+
+			code, resp := postValidate(t, NewValidateHandler(store), ValidateRequest{RawTx: hex.EncodeToString(tx.Bytes())})
+			if code != fiber.StatusOK || !resp.Valid {
+				t.Fatalf("status = %d, valid = %v", code, resp.Valid)
+			}
+			if resp.WouldWin != tt.want {
+				t.Errorf("wouldWin = %v, want %v", resp.WouldWin, tt.want)
+			}
+			if resp.Current == nil || resp.Current.TxID != current.TxID {
+				t.Errorf("current = %+v, want the stored avatar", resp.Current)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Package headers keeps a local index of block headers for SPV: checking a
+// merkle proof needs only the merkle root of the block it claims.
+package headers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/bsv-blockchain/go-sdk/chainhash"
+	"github.com/bsv-blockchain/go-sdk/transaction/chaintracker"
+)
+
+// headerSize is the length of a serialized block header.
+const headerSize = 80
+
+// genesisHash is the hash of the mainnet genesis block, display order.
+const genesisHash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+// powLimit is the easiest target a mainnet header may claim (bits 0x1d00ffff).
+var powLimit = compactToBig(0x1d00ffff)
+
+// ChainTracker is go-sdk's chaintracker.ChainTracker plus a block-hash
+// lookup, so a verified merkle proof can be turned into a confirmation.
+type ChainTracker interface {
+	chaintracker.ChainTracker
+	BlockHash(height uint32) (string, bool)
+}
+
+// Header is the part of a block header SPV needs.
+type Header struct {
+	Hash       chainhash.Hash
+	MerkleRoot chainhash.Hash
+}
+
+// Store is an in-memory header index. It is filled from a headers file and/or
+// by the subscriber as blocks are processed.
+type Store struct {
+	mu      sync.RWMutex
+	headers map[uint32]Header
+	tip     uint32
+}
+
+var _ ChainTracker = (*Store)(nil)
+
+// NewStore creates an empty header store
+func NewStore() *Store {
+	return &Store{headers: make(map[uint32]Header)}
+}
+
+// LoadFile adds the headers in a headers file: raw 80-byte block headers,
+// back to back, starting with the mainnet genesis block. Each header must
+// link to the one before it and meet the proof of work it claims, at no less
+// than the mainnet minimum; otherwise nothing is added. (Difficulty
+// adjustments aren't checked, so the file should still come from a node you
+// trust.)
+func (s *Store) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open headers file: %w", err)
+	}
+	defer f.Close()
+
+	var loaded []Header
+	var prev chainhash.Hash
+	r := bufio.NewReader(f)
+	raw := make([]byte, headerSize)
+	for height := uint32(0); ; height++ {
+		if _, err := io.ReadFull(r, raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read header %d: %w", height, err)
+		}
+		hash := chainhash.DoubleHashH(raw)
+		if err := checkHeader(height, raw, hash, prev); err != nil {
+			return fmt.Errorf("invalid header %d: %w", height, err)
+		}
+		var root chainhash.Hash
+		copy(root[:], raw[36:68])
+		loaded = append(loaded, Header{Hash: hash, MerkleRoot: root})
+		prev = hash
+	}
+
+	for height, h := range loaded {
+		s.add(uint32(height), h)
+	}
+	return nil
+}
+
+// checkHeader checks a raw header's link to the previous block's hash (the
+// genesis hash for height 0) and its proof of work.
+func checkHeader(height uint32, raw []byte, hash, prev chainhash.Hash) error {
+	if height == 0 {
+		if hash.String() != genesisHash {
+			return fmt.Errorf("not the mainnet genesis block: %s", hash)
+		}
+		return nil
+	}
+
+	var prevHash chainhash.Hash
+	copy(prevHash[:], raw[4:36])
+	if !prevHash.IsEqual(&prev) {
+		return fmt.Errorf("previous block %s, want %s", prevHash, prev)
+	}
+
+	bits := uint32(raw[72]) | uint32(raw[73])<<8 | uint32(raw[74])<<16 | uint32(raw[75])<<24
+	target := compactToBig(bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("target %08x out of range", bits)
+	}
+	if hashToBig(hash).Cmp(target) > 0 {
+		return fmt.Errorf("hash %s above target %08x", hash, bits)
+	}
+	return nil
+}
+
+// compactToBig expands a header's compact "bits" target
+func compactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if bits&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// hashToBig reads a block hash (stored little-endian) as a number
+func hashToBig(hash chainhash.Hash) *big.Int {
+	var be [chainhash.HashSize]byte
+	for i, b := range hash {
+		be[len(be)-1-i] = b
+	}
+	return new(big.Int).SetBytes(be[:])
+}
+
+// AddHeader records the header of a block on the best chain, replacing any
+// header already held for that height. Hashes are hex in display order.
+func (s *Store) AddHeader(height uint64, hash, merkleRoot string) error {
+	h, err := chainhash.NewHashFromHex(hash)
+	if err != nil {
+		return fmt.Errorf("invalid block hash: %w", err)
+	}
+	root, err := chainhash.NewHashFromHex(merkleRoot)
+	if err != nil {
+		return fmt.Errorf("invalid merkle root: %w", err)
+	}
+	s.add(uint32(height), Header{Hash: *h, MerkleRoot: *root})
+	return nil
+}
+
+func (s *Store) add(height uint32, h Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.headers[height] = h
+	if height > s.tip {
+		s.tip = height
+	}
+}
+
+// RemoveHeadersFrom forgets the headers from height up, after a reorg.
+func (s *Store) RemoveHeadersFrom(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for h := range s.headers {
+		if uint64(h) >= height {
+			delete(s.headers, h)
+		}
+	}
+	if uint64(s.tip) >= height {
+		s.tip = 0
+		for h := range s.headers {
+			s.tip = max(s.tip, h)
+		}
+	}
+}
+
+// Get returns the header at height
+func (s *Store) Get(height uint32) (Header, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.headers[height]
+	return h, ok
+}
+
+// BlockHash returns the hash of the block at height, hex in display order
+func (s *Store) BlockHash(height uint32) (string, bool) {
+	h, ok := s.Get(height)
+	if !ok {
+		return "", false
+	}
+	return h.Hash.String(), true
+}
+
+// IsValidRootForHeight reports whether root is the merkle root of the block at
+// height. A height the store has no header for is an error, not a mismatch.
+func (s *Store) IsValidRootForHeight(_ context.Context, root *chainhash.Hash, height uint32) (bool, error) {
+	h, ok := s.Get(height)
+	if !ok {
+		return false, fmt.Errorf("no header for block %d", height)
+	}
+	return h.MerkleRoot.IsEqual(root), nil
+}
+
+// CurrentHeight returns the highest height the store has a header for
+func (s *Store) CurrentHeight(context.Context) (uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tip, nil
+}
@@ -0,0 +1,111 @@
+package headers
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The first three mainnet block headers
+var mainnetHeaders = []string{
+	"0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c",
+	"010000006fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000982051fd1e4ba744bbbe680e1fee14677ba1a3c3540bf7b1cdb606e857233e0e61bc6649ffff001d01e36299",
+	"010000004860eb18bf1b1620e37e9490fc8a427514416fd75159ab86688e9a8300000000d5fdcc541e25de1c7a5addedf24858b8bb665c9f36ef744ee42c316022c90f9bb0bc6649ffff001d08d2bd61",
+}
+
+// writeHeaders writes raw headers to a file, applying edit to each first
+func writeHeaders(t *testing.T, hexHeaders []string, edit func(height int, raw []byte)) string {
+	t.Helper()
+	var data []byte
+	for i, h := range hexHeaders {
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edit != nil {
+			edit(i, raw)
+		}
+		data = append(data, raw...)
+	}
+	path := filepath.Join(t.TempDir(), "headers.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	s := NewStore()
+	if err := s.LoadFile(writeHeaders(t, mainnetHeaders, nil)); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if tip, _ := s.CurrentHeight(context.Background()); tip != 2 {
+		t.Errorf("tip = %d, want 2", tip)
+	}
+	if hash, ok := s.BlockHash(1); !ok || hash != "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048" {
+		t.Errorf("block 1 hash = %s, %v", hash, ok)
+	}
+	if hash, ok := s.BlockHash(0); !ok || hash != genesisHash {
+		t.Errorf("genesis hash = %s, %v", hash, ok)
+	}
+}
+
+func TestLoadFileRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		edit    func(height int, raw []byte)
+		want    string
+	}{
+		{
+			name:    "not from genesis",
+			headers: mainnetHeaders[1:],
+			want:    "genesis",
+		},
+		{
+			name:    "broken link",
+			headers: []string{mainnetHeaders[0], mainnetHeaders[2]},
+			want:    "previous block",
+		},
+		{
+			name:    "tampered merkle root",
+			headers: mainnetHeaders,
+			edit: func(height int, raw []byte) {
+				if height == 2 {
+					raw[40] ^= 0xff
+				}
+			},
+			want: "above target",
+		},
+		{
+			name:    "easier than the minimum",
+			headers: mainnetHeaders,
+			edit: func(height int, raw []byte) {
+				if height == 2 {
+					copy(raw[72:76], []byte{0xff, 0xff, 0x7f, 0x20}) // 0x207fffff
+				}
+			},
+			want: "out of range",
+		},
+		{
+			name:    "truncated",
+			headers: []string{mainnetHeaders[0], mainnetHeaders[1][:100]},
+			want:    "failed to read header 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			err := s.LoadFile(writeHeaders(t, tt.headers, tt.edit))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("LoadFile error = %v, want %q", err, tt.want)
+			}
+			if _, ok := s.Get(0); ok {
+				t.Error("headers added from an invalid file")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package junglebus
+
+import (
+	"context"
+)
+
+// ChainSource delivers the transactions the indexer cares about and answers
+// lookups against the best chain. JungleBusSource streams a live JungleBus
+// subscription; MemoryChain (and OpenReplay) deliver a fixed script of events,
+// for deterministic tests and for rebuilding the index from an archived dump.
+type ChainSource interface {
+	// Subscribe delivers events from fromBlock on to handler, in order, and
+	// blocks until ctx is cancelled or a finite source runs out of events.
+	Subscribe(ctx context.Context, fromBlock uint64, handler Handler) error
+	// BlockHash returns the hash of the block at height on the best chain.
+	BlockHash(ctx context.Context, height uint64) (string, error)
+	// TxBlock returns the block a transaction was mined in. A zero height
+	// means the transaction is not (or no longer) in the best chain.
+	TxBlock(ctx context.Context, txid string) (height uint64, hash string, err error)
+}
+
+// HeaderSource is implemented by chain sources that can look up full block
+// headers, for feeding an SPV header store.
+type HeaderSource interface {
+	// BlockHeader returns the hash and merkle root (hex, display order) of the
+	// block at height on the best chain.
+	BlockHeader(ctx context.Context, height uint64) (hash, merkleRoot string, err error)
+}
+
+// HeaderSink receives the headers of blocks the subscriber processes.
+type HeaderSink interface {
+	AddHeader(height uint64, hash, merkleRoot string) error
+	RemoveHeadersFrom(height uint64)
+}
+
+// Tx is a transaction delivered by a ChainSource. Block fields are zero for
+// mempool transactions.
+type Tx struct {
+	ID          string
+	Raw         []byte
+	BlockHeight uint64
+	BlockHash   string
+	BlockTime   int64
+}
+
+// StatusKind identifies a Status event.
+type StatusKind int
+
+const (
+	StatusConnected    StatusKind = iota + 1
+	StatusDisconnected            // stream lost; the source may reconnect
+	StatusWaiting                 // caught up with the chain tip
+	StatusReorg                   // blocks from Height up were orphaned
+	StatusError                   // Message describes a source-side error
+)
+
+// Status is a control event from a ChainSource.
+type Status struct {
+	Kind    StatusKind
+	Height  uint64
+	Message string
+}
+
+// Handler receives a ChainSource's events. Nil callbacks are skipped.
+type Handler struct {
+	OnTransaction func(tx *Tx)                     // confirmed transaction
+	OnMempool     func(tx *Tx)                     // unconfirmed transaction
+	OnBlockDone   func(height uint64, hash string) // every tx of the block delivered; hash may be ""
+	OnStatus      func(status Status)
+	OnError       func(err error)
+}
+
+func (h Handler) transaction(tx *Tx) {
+	if h.OnTransaction != nil {
+		h.OnTransaction(tx)
+	}
+}
+
+func (h Handler) mempool(tx *Tx) {
+	if h.OnMempool != nil {
+		h.OnMempool(tx)
+	}
+}
+
+func (h Handler) blockDone(height uint64, hash string) {
+	if h.OnBlockDone != nil {
+		h.OnBlockDone(height, hash)
+	}
+}
+
+func (h Handler) status(status Status) {
+	if h.OnStatus != nil {
+		h.OnStatus(status)
+	}
+}
+
+func (h Handler) error(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+}
@@ -0,0 +1,272 @@
+package junglebus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+// MemoryChain is a ChainSource that plays back a scripted sequence of blocks,
+// mempool transactions and reorgs. It drives the indexer deterministically in
+// tests and, loaded by OpenReplay, rebuilds an index from an archived dump.
+//
+// The chain it answers lookups from is built up as the script is delivered,
+// so BlockHash and TxBlock see the chain as the stream has shown it so far.
+// SetBlock edits that chain directly.
+type MemoryChain struct {
+	mu      sync.RWMutex
+	events  []chainEvent
+	pos     int  // next event to deliver
+	started bool // a subscription has already run
+
+	blocks map[uint64]*memBlock // height -> best-chain block
+	txs    map[string]uint64    // txid -> height
+}
+
+type eventKind int
+
+const (
+	eventBlock eventKind = iota
+	eventMempool
+	eventReorg
+)
+
+type chainEvent struct {
+	kind   eventKind
+	height uint64
+	block  *memBlock // eventBlock
+	tx     *Tx       // eventMempool
+}
+
+type memBlock struct {
+	hash  string
+	txids []string
+	txs   []*Tx // raw transactions to deliver; empty for blocks set by SetBlock
+}
+
+var _ ChainSource = (*MemoryChain)(nil)
+
+// NewMemoryChain creates an empty chain
+func NewMemoryChain() *MemoryChain {
+	return &MemoryChain{
+		blocks: make(map[uint64]*memBlock),
+		txs:    make(map[string]uint64),
+	}
+}
+
+// AddBlock appends a block holding the given raw transactions to the script.
+// A block at a height already played replaces (orphans) the earlier one.
+func (m *MemoryChain) AddBlock(height uint64, hash string, blockTime int64, rawTxs ...[]byte) error {
+	block := &memBlock{hash: hash}
+	for i, raw := range rawTxs {
+		tx, err := transaction.NewTransactionFromBytes(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse tx %d of block %d: %w", i, height, err)
+		}
+		txid := tx.TxID().String()
+		block.txids = append(block.txids, txid)
+		block.txs = append(block.txs, &Tx{
+			ID:          txid,
+			Raw:         raw,
+			BlockHeight: height,
+			BlockHash:   hash,
+			BlockTime:   blockTime,
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, chainEvent{kind: eventBlock, height: height, block: block})
+	return nil
+}
+
+// AddMempool appends an unconfirmed transaction to the script
+func (m *MemoryChain) AddMempool(raw []byte) error {
+	tx, err := transaction.NewTransactionFromBytes(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse mempool tx: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, chainEvent{kind: eventMempool, tx: &Tx{ID: tx.TxID().String(), Raw: raw}})
+	return nil
+}
+
+// AddReorg appends a reorg notice: the blocks from height up are orphaned and
+// the replacements follow in the script.
+func (m *MemoryChain) AddReorg(height uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, chainEvent{kind: eventReorg, height: height})
+}
+
+// Subscribe plays the script from fromBlock and returns once it is exhausted
+// or ctx is cancelled.
+//
+// The first subscription treats everything up to the last block below
+// fromBlock as already indexed: those blocks are added to the chain but not
+// delivered. A later subscription behaves like a reconnecting live stream: it
+// re-sends the best-chain blocks from fromBlock up, then continues the script
+// where the previous subscription stopped.
+func (m *MemoryChain) Subscribe(ctx context.Context, fromBlock uint64, handler Handler) error {
+	handler.status(Status{Kind: StatusConnected})
+
+	m.mu.Lock()
+	var resend []uint64
+	if m.started {
+		resend = m.bestChainLocked(fromBlock)
+	} else {
+		m.started = true
+		for i, ev := range m.events {
+			if ev.kind == eventBlock && ev.height < fromBlock {
+				m.pos = i + 1
+			}
+		}
+		for _, ev := range m.events[:m.pos] {
+			m.applyLocked(ev)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, height := range resend {
+		m.mu.RLock()
+		block := m.blocks[height]
+		m.mu.RUnlock()
+		if block == nil {
+			continue
+		}
+		if !deliverBlock(ctx, height, block, handler) {
+			return nil
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m.mu.Lock()
+		if m.pos >= len(m.events) {
+			m.mu.Unlock()
+			break
+		}
+		ev := m.events[m.pos]
+		m.pos++
+		m.applyLocked(ev)
+		m.mu.Unlock()
+
+		switch ev.kind {
+		case eventBlock:
+			if !deliverBlock(ctx, ev.height, ev.block, handler) {
+				return nil
+			}
+		case eventMempool:
+			handler.mempool(ev.tx)
+		case eventReorg:
+			handler.status(Status{Kind: StatusReorg, Height: ev.height})
+		}
+	}
+
+	handler.status(Status{Kind: StatusWaiting})
+	return nil
+}
+
+// deliverBlock sends a block's transactions and its block-done event,
+// reporting false if ctx was cancelled part way.
+func deliverBlock(ctx context.Context, height uint64, block *memBlock, handler Handler) bool {
+	for _, tx := range block.txs {
+		if ctx.Err() != nil {
+			return false
+		}
+		handler.transaction(tx)
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	handler.blockDone(height, block.hash)
+	return true
+}
+
+// applyLocked updates the best chain for a delivered event.
+func (m *MemoryChain) applyLocked(ev chainEvent) {
+	switch ev.kind {
+	case eventBlock:
+		m.setBlockLocked(ev.height, ev.block)
+	case eventReorg:
+		for height := range m.blocks {
+			if height >= ev.height {
+				m.removeBlockLocked(height)
+			}
+		}
+	}
+}
+
+// bestChainLocked returns the heights of best-chain blocks from fromBlock up,
+// in order.
+func (m *MemoryChain) bestChainLocked(fromBlock uint64) []uint64 {
+	var heights []uint64
+	for height := range m.blocks {
+		if height >= fromBlock {
+			heights = append(heights, height)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights
+}
+
+// SetBlock puts a block at height, replacing (orphaning) any block already
+// there along with the transactions it held. Blocks set this way answer
+// lookups but carry no transactions to deliver.
+func (m *MemoryChain) SetBlock(height uint64, hash string, txids ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setBlockLocked(height, &memBlock{hash: hash, txids: txids})
+}
+
+func (m *MemoryChain) setBlockLocked(height uint64, block *memBlock) {
+	m.removeBlockLocked(height)
+	m.blocks[height] = block
+	for _, txid := range block.txids {
+		m.txs[txid] = height
+	}
+}
+
+func (m *MemoryChain) removeBlockLocked(height uint64) {
+	old, ok := m.blocks[height]
+	if !ok {
+		return
+	}
+	for _, txid := range old.txids {
+		if m.txs[txid] == height {
+			delete(m.txs, txid)
+		}
+	}
+	delete(m.blocks, height)
+}
+
+// BlockHash returns the hash at height
+func (m *MemoryChain) BlockHash(_ context.Context, height uint64) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	block, ok := m.blocks[height]
+	if !ok {
+		return "", fmt.Errorf("block %d not found", height)
+	}
+	return block.hash, nil
+}
+
+// TxBlock returns the block holding txid, or height 0 if none does
+func (m *MemoryChain) TxBlock(_ context.Context, txid string) (uint64, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	height, ok := m.txs[txid]
+	if !ok {
+		return 0, "", nil
+	}
+	return height, m.blocks[height].hash, nil
+}
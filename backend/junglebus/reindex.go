@@ -0,0 +1,86 @@
+package junglebus
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Reindex re-processes the BitPic transactions mined in blocks from..to
+// (inclusive) and returns once block to is done, or the source reaches the
+// chain tip. Unlike Run it does not advance the stored sync cursor, so it can
+// repair a range of an index that is otherwise up to date. Records already
+// indexed are rewritten in place (SetAvatar is newest-wins).
+//
+// A block whose hash differs from the recorded one was orphaned, and is
+// rolled back first as in Run: every avatar confirmed from that block up,
+// including blocks past to, goes back to unconfirmed, and the sync cursor
+// moves back below it so the indexer's next run re-confirms them.
+//
+// Unlike the historical sync, Reindex runs the ownership check on every
+// avatar it replays.
+func (s *Subscriber) Reindex(ctx context.Context, from, to uint64) error {
+	if to < from {
+		return fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+
+	// A reindex is asked for, so avatars' owners are checked as if live.
+	s.chainMu.Lock()
+	s.reindexing = true
+	s.chainMu.Unlock()
+	defer func() {
+		s.chainMu.Lock()
+		s.reindexing = false
+		s.chainMu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first error reported by the stream, if any.
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	err := s.source.Subscribe(ctx, from, Handler{
+		OnTransaction: func(tx *Tx) {
+			if tx.BlockHeight < from || tx.BlockHeight > to {
+				return
+			}
+			if tx.BlockHash != "" {
+				s.observeBlock(tx.BlockHeight, tx.BlockHash, "reindex")
+			}
+			s.processTransaction(tx, true)
+		},
+		OnBlockDone: func(height uint64, _ string) {
+			if height >= to {
+				cancel()
+			}
+		},
+		OnStatus: func(status Status) {
+			switch status.Kind {
+			case StatusWaiting:
+				cancel() // reached the tip before block to
+			case StatusReorg:
+				log.Printf("Reindex: reorg at block %d; run the indexer to roll it back", status.Height)
+			case StatusError:
+				fail(fmt.Errorf("chain source error at block %d: %s", status.Height, status.Message))
+			}
+		},
+		OnError: fail,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
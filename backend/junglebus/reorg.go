@@ -0,0 +1,168 @@
+package junglebus
+
+import (
+	"context"
+	"log"
+)
+
+// observeBlock records the hash seen for a block height. If a different hash
+// was recorded for that height earlier, the earlier block was orphaned: every
+// avatar confirmed from that height up is rolled back before the new hash is
+// recorded. Reports whether a reorg was handled.
+func (s *Subscriber) observeBlock(height uint64, hash, source string) bool {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	if height == s.seenHeight && hash == s.seenHash {
+		return false
+	}
+
+	reorged := false
+	stored, err := s.store.GetBlockHash(height)
+	if err != nil {
+		log.Printf("Failed to get block hash for %d: %v", height, err)
+		return false
+	}
+	if stored != "" && stored != hash {
+		log.Printf("Reorg: block %d changed %s -> %s", height, stored, hash)
+		s.rollbackLocked(height, source)
+		reorged = true
+	}
+
+	if err := s.store.SetBlockHash(height, hash); err != nil {
+		log.Printf("Failed to record block hash for %d: %v", height, err)
+		return reorged
+	}
+	s.seenHeight, s.seenHash = height, hash
+	return reorged
+}
+
+// recordBlockHash looks up and records the hash of a processed block, and
+// its header if there is a header sink.
+func (s *Subscriber) recordBlockHash(height uint64) {
+	ctx := context.Background()
+	if s.headers != nil {
+		hash, merkleRoot, err := s.source.(HeaderSource).BlockHeader(ctx, height)
+		if err != nil {
+			return // the reconciler re-checks recorded blocks; a gap here is harmless
+		}
+		s.observeBlock(height, hash, "stream")
+		if err := s.headers.AddHeader(height, hash, merkleRoot); err != nil {
+			log.Printf("Failed to record header for %d: %v", height, err)
+		}
+		return
+	}
+
+	hash, err := s.source.BlockHash(ctx, height)
+	if err != nil {
+		return
+	}
+	s.observeBlock(height, hash, "stream")
+}
+
+// backfillHeaders feeds the header sink the headers of the blocks below tip
+// that it was not fed live (the historical sync skips header lookups).
+func (s *Subscriber) backfillHeaders(tip uint64) {
+	source := s.source.(HeaderSource)
+	ctx := context.Background()
+
+	from := uint64(bitpicStartBlock)
+	if tip > headerBackfillDepth {
+		from = max(from, tip-headerBackfillDepth)
+	}
+	for height := from; height <= tip; height++ {
+		hash, merkleRoot, err := source.BlockHeader(ctx, height)
+		if err != nil {
+			log.Printf("Header backfill stopped at block %d: %v", height, err)
+			return
+		}
+		if err := s.headers.AddHeader(height, hash, merkleRoot); err != nil {
+			log.Printf("Header backfill stopped at block %d: %v", height, err)
+			return
+		}
+	}
+	log.Printf("Header backfill: blocks %d-%d", from, tip)
+}
+
+// checkReorg compares the most recently recorded block hashes with the chain,
+// highest first, until one matches. Anything above the match was orphaned: it
+// is rolled back and the stream resumes from the fork point so the
+// replacement blocks get indexed.
+func (s *Subscriber) checkReorg() {
+	blocks, err := s.store.GetRecentBlocks(reorgCheckDepth)
+	if err != nil || len(blocks) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	var fork uint64
+	for _, b := range blocks {
+		hash, err := s.source.BlockHash(ctx, b.Height)
+		if err != nil {
+			return // can't tell right now — try again next cycle
+		}
+		if hash == b.Hash {
+			break
+		}
+		fork = b.Height
+	}
+	if fork == 0 {
+		return
+	}
+
+	s.rollback(fork, "reconciler")
+	s.resumeFrom(fork)
+}
+
+// rollback unconfirms every avatar confirmed at or above fromHeight.
+func (s *Subscriber) rollback(fromHeight uint64, source string) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	s.rollbackLocked(fromHeight, source)
+}
+
+func (s *Subscriber) rollbackLocked(fromHeight uint64, source string) {
+	affected, err := s.store.RollbackBlocks(fromHeight)
+	if err != nil {
+		log.Printf("Reorg: failed to roll back from block %d: %v", fromHeight, err)
+		return
+	}
+	for _, data := range affected {
+		log.Printf("Reorg: %s -> %s back to unconfirmed (was block %d)", data.Paymail, data.TxID, fromHeight)
+	}
+	log.Printf("Reorg at block %d (reported by %s): %d avatar record(s) unconfirmed", fromHeight, source, len(affected))
+
+	if s.lastBlock >= fromHeight {
+		s.lastBlock = 0
+		if fromHeight > 0 {
+			s.lastBlock = fromHeight - 1
+		}
+	}
+	// The reconciler's rollbacks restart the stream (resumeFrom), which until
+	// then is still delivering the orphaned branch; a stream-reported reorg
+	// is followed by the stream's own replacement blocks.
+	if source != "stream" && (s.forkAt == 0 || fromHeight < s.forkAt) {
+		s.forkAt = fromHeight
+	}
+	if s.seenHeight >= fromHeight {
+		s.seenHeight, s.seenHash = 0, ""
+	}
+	if s.headers != nil {
+		s.headers.RemoveHeadersFrom(fromHeight)
+	}
+}
+
+// resumeFrom restarts the stream at a fork point. The running subscription is
+// cancelled and Run resubscribes from the lowest height requested.
+func (s *Subscriber) resumeFrom(height uint64) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	if s.cancelStream == nil {
+		return
+	}
+	if s.resumeAt == 0 || height < s.resumeAt {
+		s.resumeAt = height
+	}
+	s.cancelStream()
+}
@@ -0,0 +1,179 @@
+package junglebus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenReplay loads a transaction dump into a MemoryChain. path is a dump file
+// or a directory of them, read in file-name order. A dump is line based:
+//
+//	# comment
+//	block <height> <hash> [<unix time>]
+//	tx <raw tx hex>          a transaction of the current block
+//	end                      block done (implied by the next block, mempool, reorg or EOF)
+//	mempool <raw tx hex>     an unconfirmed transaction
+//	reorg <height>           blocks from height up are orphaned
+func OpenReplay(path string) (*MemoryChain, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replay directory: %w", err)
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	chain := NewMemoryChain()
+	for _, file := range files {
+		if err := loadReplayFile(chain, file); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// replayParser reads dump lines into a MemoryChain.
+type replayParser struct {
+	chain *MemoryChain
+	block *replayBlock // block being read, if any
+}
+
+type replayBlock struct {
+	height uint64
+	hash   string
+	time   int64
+	txs    [][]byte
+}
+
+func loadReplayFile(chain *MemoryChain, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer f.Close()
+
+	// Lines hold whole transactions, so they can be far longer than
+	// bufio.Scanner allows.
+	r := bufio.NewReader(f)
+	p := &replayParser{chain: chain}
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := r.ReadString('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read %s: %w", path, readErr)
+		}
+		if err := p.line(strings.TrimSpace(line)); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if err := p.flush(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// flush adds the block being read to the chain.
+func (p *replayParser) flush() error {
+	if p.block == nil {
+		return nil
+	}
+	b := p.block
+	p.block = nil
+	return p.chain.AddBlock(b.height, b.hash, b.time, b.txs...)
+}
+
+func (p *replayParser) line(line string) error {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "block":
+		if len(fields) < 3 || len(fields) > 4 {
+			return errors.New("expected: block <height> <hash> [<unix time>]")
+		}
+		if err := p.flush(); err != nil {
+			return err
+		}
+		height, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid block height: %w", err)
+		}
+		b := &replayBlock{height: height, hash: fields[2]}
+		if len(fields) == 4 {
+			if b.time, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+				return fmt.Errorf("invalid block time: %w", err)
+			}
+		}
+		p.block = b
+	case "tx":
+		if p.block == nil {
+			return errors.New("tx outside a block")
+		}
+		raw, err := replayTxHex(fields)
+		if err != nil {
+			return err
+		}
+		p.block.txs = append(p.block.txs, raw)
+	case "end":
+		return p.flush()
+	case "mempool":
+		if err := p.flush(); err != nil {
+			return err
+		}
+		raw, err := replayTxHex(fields)
+		if err != nil {
+			return err
+		}
+		return p.chain.AddMempool(raw)
+	case "reorg":
+		if len(fields) != 2 {
+			return errors.New("expected: reorg <height>")
+		}
+		if err := p.flush(); err != nil {
+			return err
+		}
+		height, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid reorg height: %w", err)
+		}
+		p.chain.AddReorg(height)
+	default:
+		return fmt.Errorf("unknown directive %q", fields[0])
+	}
+	return nil
+}
+
+func replayTxHex(fields []string) ([]byte, error) {
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("expected: %s <raw tx hex>", fields[0])
+	}
+	raw, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid tx hex: %w", err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,121 @@
+package junglebus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/b-open-io/go-junglebus"
+	"github.com/b-open-io/go-junglebus/models"
+)
+
+// JungleBusSource streams a JungleBus subscription and answers chain lookups
+// from the JungleBus HTTP API.
+type JungleBusSource struct {
+	subscriptionID string
+	client         *junglebus.Client
+}
+
+var (
+	_ ChainSource  = (*JungleBusSource)(nil)
+	_ HeaderSource = (*JungleBusSource)(nil)
+)
+
+// NewJungleBusSource creates a source for a JungleBus subscription
+func NewJungleBusSource(junglebusURL, subscriptionID string) (*JungleBusSource, error) {
+	client, err := junglebus.New(
+		junglebus.WithHTTP(junglebusURL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JungleBus client: %w", err)
+	}
+	return &JungleBusSource{
+		subscriptionID: subscriptionID,
+		client:         client,
+	}, nil
+}
+
+// Subscribe streams the subscription from fromBlock until ctx is cancelled
+func (j *JungleBusSource) Subscribe(ctx context.Context, fromBlock uint64, handler Handler) error {
+	eventHandler := junglebus.EventHandler{
+		OnTransaction: func(tx *models.TransactionResponse) { handler.transaction(fromJungleBusTx(tx)) },
+		OnMempool:     func(tx *models.TransactionResponse) { handler.mempool(fromJungleBusTx(tx)) },
+		OnStatus:      func(status *models.ControlResponse) { j.onStatus(status, handler) },
+		OnError:       handler.error,
+	}
+
+	subscription, err := j.client.Subscribe(ctx, j.subscriptionID, fromBlock, eventHandler)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", j.subscriptionID, err)
+	}
+
+	<-ctx.Done()
+	if err := subscription.Unsubscribe(); err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	return nil
+}
+
+// onStatus translates JungleBus control messages. Reorg and wait are told
+// apart by code rather than status string.
+func (j *JungleBusSource) onStatus(status *models.ControlResponse, handler Handler) {
+	switch status.StatusCode {
+	case uint32(junglebus.SubscriptionReorg):
+		handler.status(Status{Kind: StatusReorg, Height: uint64(status.Block), Message: status.Message})
+		return
+	case uint32(junglebus.SubscriptionWait):
+		handler.status(Status{Kind: StatusWaiting, Height: uint64(status.Block)})
+	}
+
+	switch status.Status {
+	case "connected":
+		handler.status(Status{Kind: StatusConnected})
+	case "disconnected":
+		handler.status(Status{Kind: StatusDisconnected})
+	case "block-done":
+		// JungleBus doesn't send the hash; the subscriber looks it up if needed.
+		handler.blockDone(uint64(status.Block), "")
+	case "error":
+		handler.status(Status{Kind: StatusError, Height: uint64(status.Block), Message: status.Message})
+	}
+}
+
+func fromJungleBusTx(tx *models.TransactionResponse) *Tx {
+	return &Tx{
+		ID:          tx.Id,
+		Raw:         tx.Transaction,
+		BlockHeight: uint64(tx.BlockHeight),
+		BlockHash:   tx.BlockHash,
+		BlockTime:   int64(tx.BlockTime),
+	}
+}
+
+// BlockHash returns the hash of the block at height
+func (j *JungleBusSource) BlockHash(ctx context.Context, height uint64) (string, error) {
+	hash, _, err := j.BlockHeader(ctx, height)
+	return hash, err
+}
+
+// BlockHeader returns the hash and merkle root of the block at height
+func (j *JungleBusSource) BlockHeader(ctx context.Context, height uint64) (string, string, error) {
+	header, err := j.client.GetBlockHeader(ctx, strconv.FormatUint(height, 10))
+	if err != nil {
+		return "", "", err
+	}
+	if header == nil {
+		return "", "", fmt.Errorf("block %d not found", height)
+	}
+	return header.Hash, header.MerkleRoot, nil
+}
+
+// TxBlock returns the block a transaction was mined in
+func (j *JungleBusSource) TxBlock(ctx context.Context, txid string) (uint64, string, error) {
+	tx, err := j.client.GetTransaction(ctx, txid)
+	if err != nil {
+		return 0, "", err
+	}
+	if tx == nil {
+		return 0, "", nil
+	}
+	return uint64(tx.BlockHeight), tx.BlockHash, nil
+}
@@ -2,36 +2,55 @@ package junglebus
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/bsvalias"
+	"github.com/b-open-io/bitpic/diagnostics"
 	"github.com/b-open-io/bitpic/storage"
-	"github.com/b-open-io/go-junglebus"
-	"github.com/b-open-io/go-junglebus/models"
 )
 
-// Subscriber handles JungleBus subscription and transaction processing
+// Subscriber indexes BitPic transactions delivered by a ChainSource
 type Subscriber struct {
-	subscriptionID string
-	junglebusURL   string
-	redis          *storage.RedisClient
-	client         *junglebus.Client
-	subscription   *junglebus.Subscription
-	connected      bool
-	syncing        bool
-	lastBlock      uint64
-	lastBlockTime  time.Time
+	source ChainSource
+	store  storage.Store
+
+	// The running stream, so a reorg can restart it from the fork point.
+	streamMu     sync.Mutex
+	cancelStream context.CancelFunc
+	resumeAt     uint64 // non-zero: restart the stream here
+
+	// Reorg tracking: serializes block-hash bookkeeping, rollbacks and the
+	// sync cursor between the stream and the reconciler.
+	chainMu       sync.Mutex
+	seenHeight    uint64 // last block hash recorded, to skip repeat writes
+	seenHash      string
+	forkAt        uint64 // non-zero: rolled back from here, stream not yet restarted
+	connected     bool
+	syncing       bool
+	live          bool // caught up with the chain tip
+	lastBlock     uint64
+	lastBlockTime time.Time
+
+	headers HeaderSink             // optional SPV header store, fed near the tip
+	rejects *diagnostics.RejectLog // optional log of BitPic txs that failed validation
+	blobs   storage.BlobStore      // optional store for embedded image bytes
+	owners  *bsvalias.Verifier     // optional paymail ownership check
+
+	reindexing bool // Reindex is replaying blocks: check owners as if live
 
 	// Stats for batched logging
-	statsMu        sync.Mutex
-	txCount        uint64
-	blockCount     uint64
-	bitpicCount    uint64
-	parseErrors    uint64
-	lastLogBlock   uint64
-	lastLogTime    time.Time
+	statsMu      sync.Mutex
+	txCount      uint64
+	blockCount   uint64
+	bitpicCount  uint64
+	parseErrors  uint64
+	lastLogBlock uint64
+	lastLogTime  time.Time
 }
 
 const (
@@ -44,196 +63,383 @@ const (
 	// during the block) doesn't leave a record stuck in "pending" forever.
 	reconcileInterval  = 60 * time.Second
 	reconcileScanLimit = 200
+
+	// How many of the most recent recorded blocks the reconciler may walk back
+	// through when looking for a fork point.
+	reorgCheckDepth = 100
+
+	// On reaching the tip, how far back to fetch headers for the header sink.
+	// Older proofs need a headers file.
+	headerBackfillDepth = 1000
+
+	// Upper bound on one avatar's ownership check, which may take several
+	// requests to the paymail's host, each bounded by the verifier's timeout.
+	ownerCheckTimeout = 30 * time.Second
 )
 
-// NewSubscriber creates a new JungleBus subscriber
-func NewSubscriber(junglebusURL, subscriptionID string, redis *storage.RedisClient) *Subscriber {
+// NewSubscriber creates a subscriber indexing source into store
+func NewSubscriber(source ChainSource, store storage.Store) *Subscriber {
 	return &Subscriber{
-		subscriptionID: subscriptionID,
-		junglebusURL:   junglebusURL,
-		redis:          redis,
-		lastLogTime:    time.Now(),
+		source:      source,
+		store:       store,
+		lastLogTime: time.Now(),
 	}
 }
 
-// Start begins listening to JungleBus subscription
-func (s *Subscriber) Start() error {
-	// Create JungleBus client
-	client, err := junglebus.New(
-		junglebus.WithHTTP(s.junglebusURL),
-	)
-	if err != nil {
-		return err
+// SetHeaderSink makes the subscriber record the header of every block it
+// processes at the tip (and backfill recent ones on reaching it) into sink.
+// The chain source must implement HeaderSource; call before Start.
+func (s *Subscriber) SetHeaderSink(sink HeaderSink) error {
+	if _, ok := s.source.(HeaderSource); !ok {
+		return errors.New("chain source cannot look up block headers")
 	}
-	s.client = client
+	s.headers = sink
+	return nil
+}
 
-	// Get last block from Redis, never go below BitPic start block
-	lastBlock, err := s.redis.GetLastBlock()
-	if err != nil {
-		log.Printf("Warning: failed to get last block from Redis: %v", err)
-		lastBlock = bitpicStartBlock
-	}
+// SetRejectLog makes the subscriber record transactions that carry a BitPic
+// tape but fail validation into rejects. Call before Start.
+func (s *Subscriber) SetRejectLog(rejects *diagnostics.RejectLog) {
+	s.rejects = rejects
+}
 
-	// Always use at least bitpicStartBlock - never sync earlier blocks
-	if lastBlock < bitpicStartBlock {
-		log.Printf("Stored block %d is before BitPic genesis, starting from %d", lastBlock, bitpicStartBlock)
-		lastBlock = bitpicStartBlock
-	} else {
-		log.Printf("Resuming from block %d", lastBlock)
-	}
+// SetContentStore makes the subscriber keep the bytes of every embedded
+// avatar image it indexes in blobs. Call before Start.
+func (s *Subscriber) SetContentStore(blobs storage.BlobStore) {
+	s.blobs = blobs
+}
 
-	log.Printf("Connecting to JungleBus subscription: %s", s.subscriptionID)
+// SetOwnerVerifier makes the subscriber check each avatar's signing key
+// against its paymail's host, recording the result and, if owners requires
+// it, rejecting avatars whose owner isn't verified. Avatars confirmed during
+// the historical sync are stored unchecked. Call before Start.
+func (s *Subscriber) SetOwnerVerifier(owners *bsvalias.Verifier) {
+	s.owners = owners
+}
 
-	// Set up event handlers
-	eventHandler := junglebus.EventHandler{
-		OnTransaction: s.onTransaction,
-		OnMempool:     s.onMempool,
-		OnStatus:      s.onStatus,
-		OnError:       s.onError,
-	}
+// Start indexes the source until it runs out of events, which a live source
+// never does
+func (s *Subscriber) Start() error {
+	return s.Run(context.Background())
+}
 
-	// Subscribe to the stream
-	subscription, err := client.Subscribe(
-		context.Background(),
-		s.subscriptionID,
-		lastBlock,
-		eventHandler,
-	)
+// Run indexes the source until ctx is cancelled or the source runs out of
+// events. A reorg restarts the stream from the fork point.
+func (s *Subscriber) Run(ctx context.Context) error {
+	// Get last block from the store, never go below BitPic start block
+	fromBlock, err := s.store.GetLastBlock()
 	if err != nil {
-		return err
+		log.Printf("Warning: failed to get last block from store: %v", err)
+		fromBlock = bitpicStartBlock
 	}
 
-	s.subscription = subscription
-	s.connected = true
-	s.syncing = true
+	// Always use at least bitpicStartBlock - never sync earlier blocks
+	if fromBlock < bitpicStartBlock {
+		log.Printf("Stored block %d is before BitPic genesis, starting from %d", fromBlock, bitpicStartBlock)
+		fromBlock = bitpicStartBlock
+	} else {
+		log.Printf("Resuming from block %d", fromBlock)
+	}
 
-	log.Println("Subscribed to JungleBus, listening for transactions...")
+	// Self-healing confirmation: independent of the block stream.
+	reconcileCtx, stopReconcile := context.WithCancel(ctx)
+	defer stopReconcile()
+	go s.reconcileLoop(reconcileCtx)
 
-	// Self-healing confirmation: independent of the live block stream.
-	go s.reconcileLoop()
+	for {
+		streamCtx, cancel := context.WithCancel(ctx)
+		s.streamMu.Lock()
+		s.cancelStream = cancel
+		s.resumeAt = 0
+		s.streamMu.Unlock()
 
-	// Block forever - the subscription runs in its own goroutine
-	select {}
+		s.chainMu.Lock()
+		s.connected = true
+		s.syncing = true
+		s.live = false
+		s.forkAt = 0
+		s.chainMu.Unlock()
+		err := s.source.Subscribe(streamCtx, fromBlock, Handler{
+			OnTransaction: s.onTransaction,
+			OnMempool:     s.onMempool,
+			OnBlockDone:   s.onBlockDone,
+			OnStatus:      s.onStatus,
+			OnError:       s.onError,
+		})
+		cancel()
+
+		s.streamMu.Lock()
+		s.cancelStream = nil
+		resumeAt := s.resumeAt
+		s.streamMu.Unlock()
+
+		if err != nil || resumeAt == 0 || ctx.Err() != nil {
+			s.chainMu.Lock()
+			s.connected = false
+			s.syncing = false
+			s.chainMu.Unlock()
+			return err
+		}
+		fromBlock = resumeAt
+		log.Printf("Reorg: resuming stream from block %d", fromBlock)
+	}
 }
 
 // reconcileLoop periodically confirms recent pending avatars by checking the
 // chain directly, so the pending -> confirmed transition is not lost when a
 // live block-done event is missed (e.g. the indexer restarted during the block).
-func (s *Subscriber) reconcileLoop() {
+//
+// It also re-checks the recorded block hashes against the chain, catching
+// reorgs the stream never reported.
+func (s *Subscriber) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
 	for {
-		time.Sleep(reconcileInterval)
-		s.reconcilePending()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reconcilePending()
+			s.checkReorg()
+		}
 	}
 }
 
 func (s *Subscriber) reconcilePending() {
-	paymails, err := s.redis.GetRecentPaymails(reconcileScanLimit)
+	paymails, err := s.store.GetRecentPaymails(reconcileScanLimit)
 	if err != nil {
 		return
 	}
 	ctx := context.Background()
 	for _, paymail := range paymails {
-		data, err := s.redis.GetAvatarData(paymail)
+		data, err := s.store.GetAvatarData(paymail)
 		if err != nil || data == nil || data.Confirmed {
 			continue
 		}
-		tx, err := s.client.GetTransaction(ctx, data.TxID)
-		if err != nil || tx == nil || tx.BlockHeight == 0 {
+		height, hash, err := s.source.TxBlock(ctx, data.TxID)
+		if err != nil || height == 0 {
 			continue // still unconfirmed (or lookup failed) — try again next cycle
 		}
+		// The reconciler's view of the block doubles as a reorg check.
+		if s.observeBlock(height, hash, "reconciler") {
+			s.resumeFrom(height)
+		}
+
 		// Confirmed on-chain. Preserve the timestamp so feed ordering is stable.
-		if err := s.redis.SetAvatar(data.Paymail, data.Outpoint, data.TxID, data.Timestamp, true, data.IsRef, data.RefOrigin); err != nil {
+		data.Confirmed = true
+		data.BlockHeight = height
+		data.BlockHash = hash
+		if err := s.store.SetAvatar(data); err != nil {
 			log.Printf("reconcile: failed to confirm %s: %v", data.Paymail, err)
 			continue
 		}
-		log.Printf("reconcile: confirmed %s @ block %d", data.Paymail, tx.BlockHeight)
+		log.Printf("reconcile: confirmed %s @ block %d", data.Paymail, height)
 	}
 }
 
 // GetStatus returns the current subscriber status
 func (s *Subscriber) GetStatus() (connected bool, syncing bool, lastBlock uint64, lastBlockTime time.Time) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
 	return s.connected, s.syncing, s.lastBlock, s.lastBlockTime
 }
 
 // onTransaction handles confirmed transactions
-func (s *Subscriber) onTransaction(tx *models.TransactionResponse) {
+func (s *Subscriber) onTransaction(tx *Tx) {
 	s.statsMu.Lock()
 	s.txCount++
 	s.statsMu.Unlock()
+	if tx.BlockHash != "" {
+		s.observeBlock(tx.BlockHeight, tx.BlockHash, "stream")
+	}
 	s.processTransaction(tx, true)
 }
 
 // onMempool handles unconfirmed transactions
-func (s *Subscriber) onMempool(tx *models.TransactionResponse) {
+func (s *Subscriber) onMempool(tx *Tx) {
 	s.processTransaction(tx, false)
 }
 
+// onBlockDone records progress once every transaction of a block is processed
+func (s *Subscriber) onBlockDone(height uint64, hash string) {
+	// Save progress every block (silently). Blocks of an orphaned branch the
+	// stream delivers before a reconciler rollback restarts it must not move
+	// the cursor back past the fork.
+	s.chainMu.Lock()
+	if s.forkAt != 0 && height >= s.forkAt {
+		s.chainMu.Unlock()
+		return
+	}
+	s.lastBlock = height
+	s.lastBlockTime = time.Now()
+	s.store.SetLastBlock(height)
+	live := s.live
+	s.chainMu.Unlock()
+
+	if hash != "" {
+		s.observeBlock(height, hash, "stream")
+	} else if live {
+		// Near the tip, record every block's hash so the reconciler can spot
+		// a fork even in blocks without BitPic transactions. (During the
+		// historical sync that would be one lookup per block, and deep
+		// history does not reorg.)
+		go s.recordBlockHash(height)
+	}
+
+	// Batched logging
+	s.statsMu.Lock()
+	s.blockCount++
+	blocksSinceLog := height - s.lastLogBlock
+	timeSinceLog := time.Since(s.lastLogTime)
+
+	// Log if enough blocks or enough time has passed
+	if blocksSinceLog >= logBlocksEvery || timeSinceLog >= logInterval {
+		log.Printf("Sync: block %d | %d blocks, %d txs, %d bitpics indexed",
+			height, s.blockCount, s.txCount, s.bitpicCount)
+		s.lastLogBlock = height
+		s.lastLogTime = time.Now()
+		s.blockCount = 0
+		s.txCount = 0
+	}
+	s.statsMu.Unlock()
+}
+
 // onStatus handles status updates
-func (s *Subscriber) onStatus(status *models.ControlResponse) {
-	switch status.Status {
-	case "connected":
-		s.connected = true
-		log.Printf("JungleBus connected")
-	case "disconnected":
-		s.connected = false
-		log.Printf("JungleBus disconnected")
-	case "block-done":
-		s.lastBlock = uint64(status.Block)
-		s.lastBlockTime = time.Now()
-		// Save progress every block (silently)
-		s.redis.SetLastBlock(uint64(status.Block))
-
-		// Batched logging
-		s.statsMu.Lock()
-		s.blockCount++
-		blocksSinceLog := s.lastBlock - s.lastLogBlock
-		timeSinceLog := time.Since(s.lastLogTime)
-
-		// Log if enough blocks or enough time has passed
-		if blocksSinceLog >= logBlocksEvery || timeSinceLog >= logInterval {
-			log.Printf("Sync: block %d | %d blocks, %d txs, %d bitpics indexed",
-				s.lastBlock, s.blockCount, s.txCount, s.bitpicCount)
-			s.lastLogBlock = s.lastBlock
-			s.lastLogTime = time.Now()
-			s.blockCount = 0
-			s.txCount = 0
+func (s *Subscriber) onStatus(status Status) {
+	switch status.Kind {
+	case StatusReorg:
+		s.rollback(status.Height, "stream")
+	case StatusWaiting:
+		s.chainMu.Lock()
+		if !s.live && s.headers != nil {
+			go s.backfillHeaders(s.lastBlock)
 		}
-		s.statsMu.Unlock()
-	case "error":
-		log.Printf("JungleBus error: %s", status.Message)
+		s.live = true
+		s.chainMu.Unlock()
+	case StatusConnected:
+		s.setConnected(true)
+		log.Printf("Chain source connected")
+	case StatusDisconnected:
+		s.setConnected(false)
+		log.Printf("Chain source disconnected")
+	case StatusError:
+		log.Printf("Chain source error: %s", status.Message)
 	}
 }
 
 // onError handles errors
 func (s *Subscriber) onError(err error) {
-	log.Printf("JungleBus error: %v", err)
-	s.connected = false
+	log.Printf("Chain source error: %v", err)
+	s.setConnected(false)
 }
 
-// processTransaction processes a transaction from JungleBus
-func (s *Subscriber) processTransaction(tx *models.TransactionResponse, confirmed bool) {
-	// Transaction is already bytes from JungleBus
-	txBytes := tx.Transaction
+func (s *Subscriber) setConnected(connected bool) {
+	s.chainMu.Lock()
+	s.connected = connected
+	s.chainMu.Unlock()
+}
 
-	// Parse BitPic data
-	data, err := bitpic.ParseTransaction(txBytes)
+// processTransaction processes a transaction from the chain source, storing
+// an avatar for each valid BitPic output
+func (s *Subscriber) processTransaction(tx *Tx, confirmed bool) {
+	// Non-BitPic transactions are expected and ignored silently
+	results, err := bitpic.ParseTransactionAll(tx.Raw)
 	if err != nil {
-		// Silently ignore non-BitPic transactions (expected)
 		s.statsMu.Lock()
 		s.parseErrors++
 		s.statsMu.Unlock()
 		return
 	}
 
+	for _, r := range results {
+		if r.Err != nil {
+			s.reject(tx, "", r.Err)
+			continue
+		}
+		s.storeAvatar(tx, r.Data, confirmed)
+	}
+}
+
+// reject records a BitPic output that failed validation or the ownership
+// policy; unlike a non-BitPic transaction, it is worth explaining.
+func (s *Subscriber) reject(tx *Tx, paymail string, err error) {
+	s.statsMu.Lock()
+	s.parseErrors++
+	s.statsMu.Unlock()
+
+	reason, detail := bitpic.Reason(err), err
+	var perr *bitpic.ParseError
+	switch {
+	case errors.As(err, &perr):
+		paymail, detail = perr.Paymail, perr.Err
+	case errors.Is(err, bsvalias.ErrNotOwner):
+		reason = bsvalias.Reason
+	}
+	log.Printf("BitPic rejected (%s): %v", reason, err)
+	if s.rejects != nil {
+		s.rejects.Add(diagnostics.Reject{
+			TxID:        tx.ID,
+			Paymail:     paymail,
+			Reason:      reason,
+			Detail:      detail.Error(),
+			BlockHeight: tx.BlockHeight,
+			Source:      diagnostics.SourceIndexer,
+		})
+	}
+}
+
+// checksOwner reports whether an avatar gets the ownership check. Those
+// confirmed during the historical sync don't: the host's current key says
+// nothing about the key a paymail used years ago, and one slow host would
+// hold up the sync. Mempool avatars, blocks at the tip and Reindex are checked.
+func (s *Subscriber) checksOwner(confirmed bool) bool {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	return !confirmed || s.live || s.reindexing
+}
+
+// storeAvatar stores the avatar one BitPic output sets
+func (s *Subscriber) storeAvatar(tx *Tx, data *bitpic.BitPicData, confirmed bool) {
 	// Use block time if available, otherwise use current time
-	timestamp := int64(tx.BlockTime)
+	timestamp := tx.BlockTime
 	if timestamp == 0 {
 		timestamp = time.Now().Unix()
 	}
 	data.Timestamp = timestamp
 
-	// Store in Redis
-	if err := s.redis.SetAvatar(data.Paymail, data.Outpoint, tx.Id, timestamp, confirmed, data.IsRef, data.RefOrigin); err != nil {
+	// Store the avatar
+	avatar := &storage.AvatarData{
+		Outpoint:  data.Outpoint,
+		Timestamp: timestamp,
+		Paymail:   data.Paymail,
+		PubKey:    strings.ToLower(data.PubKey),
+		TxID:      tx.ID,
+		Confirmed: confirmed,
+		IsRef:     data.IsRef,
+		RefOrigin: data.RefOrigin,
+		RefURIs:   data.RefURIs,
+		ImageHash: data.ImageHash,
+	}
+	if confirmed {
+		avatar.BlockHeight = tx.BlockHeight
+		avatar.BlockHash = tx.BlockHash
+	}
+	if s.owners != nil && s.checksOwner(confirmed) {
+		ctx, cancel := context.WithTimeout(context.Background(), ownerCheckTimeout)
+		owner, err := s.owners.Check(ctx, data.Paymail, data.PubKey)
+		cancel()
+		avatar.Owner = owner
+		if err != nil {
+			s.reject(tx, data.Paymail, err)
+			return
+		}
+	}
+	if s.blobs != nil && data.Image != nil {
+		if _, err := s.blobs.PutBlob(data.Image); err != nil {
+			log.Printf("Failed to store image for %s: %v", data.Outpoint, err)
+		}
+	}
+	if err := s.store.SetAvatar(avatar); err != nil {
 		log.Printf("Failed to store avatar for %s: %v", data.Paymail, err)
 		return
 	}
@@ -247,5 +453,5 @@ func (s *Subscriber) processTransaction(tx *models.TransactionResponse, confirme
 	if confirmed {
 		status = "confirmed"
 	}
-	log.Printf("BitPic (%s): %s -> %s @ block %d", status, data.Paymail, tx.Id, tx.BlockHeight)
+	log.Printf("BitPic (%s): %s -> %s @ block %d", status, data.Paymail, data.Outpoint, tx.BlockHeight)
 }
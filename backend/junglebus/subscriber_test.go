@@ -0,0 +1,349 @@
+package junglebus
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/b-open-io/bitpic/bitpic"
+	"github.com/b-open-io/bitpic/bsvalias"
+	"github.com/b-open-io/bitpic/storage"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+)
+
+const testHeight = bitpicStartBlock + 100
+
+// avatarTx returns a raw transaction setting paymail's avatar to an embedded
+// image, and its txid. nonce tells transactions for one paymail apart.
+func avatarTx(t *testing.T, paymail string, nonce uint32) ([]byte, string) {
+	t.Helper()
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := bitpic.Embed([]byte(fmt.Sprintf("\x89PNG\r\n\x1a\n%d", nonce)), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := bitpic.NewOutput(content, paymail, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTransaction()
+	tx.LockTime = nonce
+	tx.AddOutput(out)
+	return tx.Bytes(), tx.TxID().String()
+}
+
+// runSubscriber indexes chain into store until the script runs out
+func runSubscriber(t *testing.T, chain ChainSource, store storage.Store) *Subscriber {
+	t.Helper()
+	s := NewSubscriber(chain, store)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return s
+}
+
+func mustAvatar(t *testing.T, store storage.Store, paymail string) *storage.AvatarData {
+	t.Helper()
+	data, err := store.GetAvatarData(paymail)
+	if err != nil || data == nil {
+		t.Fatalf("GetAvatarData(%s) = %v, %v", paymail, data, err)
+	}
+	return data
+}
+
+func TestSubscriberConfirmsMempoolAvatar(t *testing.T) {
+	raw, txid := avatarTx(t, "alice@example.com", 1)
+	chain := NewMemoryChain()
+	if err := chain.AddMempool(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(testHeight, "hash-a", 1700000000, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	s := runSubscriber(t, chain, store)
+
+	data := mustAvatar(t, store, "alice@example.com")
+	if data.TxID != txid || !data.Confirmed || data.BlockHeight != testHeight || data.BlockHash != "hash-a" {
+		t.Errorf("avatar = %+v, want %s confirmed in block %d", data, txid, testHeight)
+	}
+	if data.Timestamp != 1700000000 {
+		t.Errorf("timestamp = %d, want the block time", data.Timestamp)
+	}
+	if last, _ := store.GetLastBlock(); last != testHeight {
+		t.Errorf("last block = %d, want %d", last, testHeight)
+	}
+	if _, _, last, _ := s.GetStatus(); last != testHeight {
+		t.Errorf("status last block = %d, want %d", last, testHeight)
+	}
+}
+
+func TestSubscriberReorgUnconfirms(t *testing.T) {
+	raw, _ := avatarTx(t, "bob@example.com", 1)
+	chain := NewMemoryChain()
+	if err := chain.AddBlock(testHeight, "hash-a", 1700000000, raw); err != nil {
+		t.Fatal(err)
+	}
+	chain.AddReorg(testHeight)
+	if err := chain.AddBlock(testHeight, "hash-b", 1700000000); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	runSubscriber(t, chain, store)
+
+	if data := mustAvatar(t, store, "bob@example.com"); data.Confirmed || data.BlockHeight != 0 {
+		t.Errorf("avatar = %+v, want unconfirmed after the reorg", data)
+	}
+	if hash, _ := store.GetBlockHash(testHeight); hash != "hash-b" {
+		t.Errorf("block hash = %q, want hash-b", hash)
+	}
+}
+
+// A reorg found by the reconciler rewinds the cursor, and blocks the stream
+// delivers before it restarts don't move it past the fork again.
+func TestSubscriberReconcilerReorg(t *testing.T) {
+	raw, _ := avatarTx(t, "carol@example.com", 1)
+	chain := NewMemoryChain()
+	if err := chain.AddBlock(testHeight, "hash-a", 1700000000, raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(testHeight+1, "hash-a1", 1700000600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	s := runSubscriber(t, chain, store)
+	if !mustAvatar(t, store, "carol@example.com").Confirmed {
+		t.Fatal("avatar not confirmed")
+	}
+
+	chain.SetBlock(testHeight, "hash-b")
+	chain.SetBlock(testHeight+1, "hash-b1")
+	s.checkReorg()
+
+	if mustAvatar(t, store, "carol@example.com").Confirmed {
+		t.Error("avatar still confirmed after the reorg")
+	}
+	if last, _ := store.GetLastBlock(); last != testHeight-1 {
+		t.Errorf("last block = %d, want %d", last, testHeight-1)
+	}
+
+	s.onBlockDone(testHeight+1, "")
+	if last, _ := store.GetLastBlock(); last != testHeight-1 {
+		t.Errorf("orphaned block moved the cursor to %d", last)
+	}
+	if _, _, last, _ := s.GetStatus(); last != testHeight-1 {
+		t.Errorf("status last block = %d, want %d", last, testHeight-1)
+	}
+}
+
+// writeReplay writes a dump of blocks (height -> raw txs) in height order
+func writeReplay(t *testing.T, path string, from, to uint64, txs map[uint64][]byte) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("# test dump\n")
+	for height := from; height <= to; height++ {
+		fmt.Fprintf(&b, "block %d hash-%d %d\n", height, height, 1700000000+int64(height-from)*600)
+		if raw, ok := txs[height]; ok {
+			fmt.Fprintf(&b, "tx %s\n", hex.EncodeToString(raw))
+		}
+		b.WriteString("end\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A restarted indexer resumes at its stored cursor: blocks below it are taken
+// as indexed, the ones after it are delivered.
+func TestSubscriberResumesFromReplay(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := avatarTx(t, "dave@example.com", 1)
+	skipped, _ := avatarTx(t, "erin@example.com", 2)
+	later, laterID := avatarTx(t, "frank@example.com", 3)
+
+	store := storage.NewMemoryStore()
+	writeReplay(t, filepath.Join(dir, "1.dump"), testHeight, testHeight+1, map[uint64][]byte{testHeight: first})
+	chain, err := OpenReplay(filepath.Join(dir, "1.dump"))
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	runSubscriber(t, chain, store)
+	if last, _ := store.GetLastBlock(); last != testHeight+1 {
+		t.Fatalf("last block = %d, want %d", last, testHeight+1)
+	}
+
+	// The same blocks again, now with a tx the first run never saw below the
+	// cursor, then new blocks
+	writeReplay(t, filepath.Join(dir, "2.dump"), testHeight, testHeight+3, map[uint64][]byte{
+		testHeight:     skipped,
+		testHeight + 2: later,
+	})
+	chain, err = OpenReplay(filepath.Join(dir, "2.dump"))
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	runSubscriber(t, chain, store)
+
+	if data, _ := store.GetAvatarData("erin@example.com"); data != nil {
+		t.Errorf("block below the cursor was re-indexed: %+v", data)
+	}
+	if data := mustAvatar(t, store, "frank@example.com"); data.TxID != laterID || data.BlockHeight != testHeight+2 {
+		t.Errorf("avatar = %+v, want %s in block %d", data, laterID, testHeight+2)
+	}
+	if data := mustAvatar(t, store, "dave@example.com"); !data.Confirmed {
+		t.Errorf("first run's avatar lost: %+v", data)
+	}
+	if last, _ := store.GetLastBlock(); last != testHeight+3 {
+		t.Errorf("last block = %d, want %d", last, testHeight+3)
+	}
+}
+
+// Reindex picks up a missed tx in a range without moving the sync cursor
+func TestReindexKeepsCursor(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := avatarTx(t, "gina@example.com", 1)
+	missed, missedID := avatarTx(t, "hank@example.com", 2)
+
+	store := storage.NewMemoryStore()
+	writeReplay(t, filepath.Join(dir, "1.dump"), testHeight, testHeight+3, map[uint64][]byte{testHeight: first})
+	chain, err := OpenReplay(filepath.Join(dir, "1.dump"))
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	runSubscriber(t, chain, store)
+
+	writeReplay(t, filepath.Join(dir, "2.dump"), testHeight, testHeight+3, map[uint64][]byte{testHeight + 1: missed})
+	chain, err = OpenReplay(filepath.Join(dir, "2.dump"))
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	if err := NewSubscriber(chain, store).Reindex(context.Background(), testHeight+1, testHeight+1); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+
+	if data := mustAvatar(t, store, "hank@example.com"); data.TxID != missedID || data.BlockHeight != testHeight+1 {
+		t.Errorf("avatar = %+v, want %s in block %d", data, missedID, testHeight+1)
+	}
+	if last, _ := store.GetLastBlock(); last != testHeight+3 {
+		t.Errorf("last block = %d, want %d", last, testHeight+3)
+	}
+}
+
+// A reorged block in the range rolls back everything above it, past the end
+// of the range, and moves the cursor back below it
+func TestReindexRollsBackReorg(t *testing.T) {
+	inRange, _ := avatarTx(t, "ivy@example.com", 1)
+	above, _ := avatarTx(t, "jack@example.com", 2)
+
+	chain := NewMemoryChain()
+	for height := uint64(testHeight); height <= testHeight+3; height++ {
+		var txs [][]byte
+		switch height {
+		case testHeight + 1:
+			txs = append(txs, inRange)
+		case testHeight + 3:
+			txs = append(txs, above)
+		}
+		if err := chain.AddBlock(height, fmt.Sprintf("hash-%d", height), 1700000000, txs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := storage.NewMemoryStore()
+	runSubscriber(t, chain, store)
+
+	chain = NewMemoryChain()
+	if err := chain.AddBlock(testHeight+1, "hash-new", 1700000000, inRange); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSubscriber(chain, store).Reindex(context.Background(), testHeight+1, testHeight+1); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+
+	if data := mustAvatar(t, store, "ivy@example.com"); !data.Confirmed || data.BlockHash != "hash-new" {
+		t.Errorf("avatar in range = %+v, want confirmed in hash-new", data)
+	}
+	if data := mustAvatar(t, store, "jack@example.com"); data.Confirmed {
+		t.Errorf("avatar above the range = %+v, want unconfirmed", data)
+	}
+	if last, _ := store.GetLastBlock(); last != testHeight {
+		t.Errorf("last block = %d, want %d", last, testHeight)
+	}
+}
+
+// downOwners returns a "record" ownership verifier whose every paymail host
+// fails, and a count of the requests made to it
+func downOwners(t *testing.T) (*bsvalias.Verifier, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	host := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(host.Close)
+
+	owners := bsvalias.NewVerifier(time.Second, time.Hour, false)
+	owners.SetHTTPClient(host.Client())
+	owners.SetHostResolver(func(context.Context, string) (string, error) { return host.URL, nil })
+	return owners, &hits
+}
+
+// Avatars confirmed during the historical sync are stored unchecked; mempool
+// avatars, blocks at the tip and reindexed blocks get the ownership check
+func TestSubscriberOwnerCheck(t *testing.T) {
+	historical, _ := avatarTx(t, "kim@example.com", 1)
+	chain := NewMemoryChain()
+	if err := chain.AddBlock(testHeight, "hash-a", 1700000000, historical); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	owners, hits := downOwners(t)
+	s := NewSubscriber(chain, store)
+	s.SetOwnerVerifier(owners)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if data := mustAvatar(t, store, "kim@example.com"); data.Owner != "" || hits.Load() != 0 {
+		t.Errorf("historical avatar owner = %q after %d host requests, want unchecked", data.Owner, hits.Load())
+	}
+
+	// Run has reached the tip
+	mempool, _ := avatarTx(t, "lee@example.com", 2)
+	s.processTransaction(&Tx{ID: "mempool", Raw: mempool}, false)
+	tip, _ := avatarTx(t, "max@example.com", 3)
+	s.processTransaction(&Tx{ID: "tip", Raw: tip, BlockHeight: testHeight + 1, BlockHash: "hash-b", BlockTime: 1700000600}, true)
+	for _, paymail := range []string{"lee@example.com", "max@example.com"} {
+		if data := mustAvatar(t, store, paymail); data.Owner != bsvalias.OwnerUnknown {
+			t.Errorf("%s owner = %q, want %q", paymail, data.Owner, bsvalias.OwnerUnknown)
+		}
+	}
+
+	reindexed, _ := avatarTx(t, "ned@example.com", 4)
+	chain = NewMemoryChain()
+	if err := chain.AddBlock(testHeight, "hash-a", 1700000000, reindexed); err != nil {
+		t.Fatal(err)
+	}
+	s = NewSubscriber(chain, store)
+	s.SetOwnerVerifier(owners)
+	if err := s.Reindex(context.Background(), testHeight, testHeight); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if data := mustAvatar(t, store, "ned@example.com"); data.Owner != bsvalias.OwnerUnknown {
+		t.Errorf("reindexed avatar owner = %q, want %q", data.Owner, bsvalias.OwnerUnknown)
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/b-open-io/bitpic/bsvalias"
+	"github.com/b-open-io/bitpic/content"
+	"github.com/b-open-io/bitpic/diagnostics"
 	"github.com/b-open-io/bitpic/handlers"
+	"github.com/b-open-io/bitpic/headers"
 	"github.com/b-open-io/bitpic/junglebus"
 	"github.com/b-open-io/bitpic/storage"
+	"github.com/bsv-blockchain/go-sdk/transaction/broadcaster"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
@@ -25,10 +32,26 @@ func main() {
 	// Get configuration from environment
 	port := getEnv("PORT", "8080")
 	redisURL := getEnv("REDIS_URL", "redis://localhost:6379")
+	storageURL := getEnv("STORAGE_URL", redisURL)     // redis://, sqlite://<path> or memory://
+	contentStoreURL := os.Getenv("CONTENT_STORE_URL") // file://<dir>, redis:// or memory://; keeps embedded images
 	junglebusURL := getEnv("JUNGLEBUS_URL", "https://junglebus.gorillapool.io")
 	subscriptionID := getEnv("JUNGLEBUS_SUBSCRIPTION_ID", "d40d60de8e6fdaa627eefb14ea685052f5955e278d54f19e6564d6c5e5015eb3")
-	ordfsURL := getEnv("ORDFS_URL", "https://ordfs.network")
+	replayPath := os.Getenv("REPLAY_PATH") // index from a tx dump instead of JungleBus
+	ordfsURLs := getEnv("ORDFS_URL", "https://ordfs.network")
+	ordfsTimeoutStr := getEnv("ORDFS_TIMEOUT", "10")
+	contentHashURLs := os.Getenv("CONTENT_HASH_URLS")
+	avatarRedirect := getEnv("AVATAR_REDIRECT", "false") == "true"
+	defaultURLMode := getEnv("DEFAULT_URL_MODE", "off")
+	defaultURLHosts := os.Getenv("DEFAULT_URL_HOSTS")
+	ownerCheck := getEnv("OWNER_CHECK", "off")
+	paymailTimeoutStr := getEnv("PAYMAIL_TIMEOUT", "10")
+	paymailCacheTTLStr := getEnv("PAYMAIL_CACHE_TTL", "3600")
 	arcURL := getEnv("ARC_URL", "https://arc.taal.com")
+	arcAPIKey := os.Getenv("ARC_API_KEY")
+	arcCallbackURL := os.Getenv("ARC_CALLBACK_URL") // public URL of /api/arc/callback
+	arcCallbackToken := os.Getenv("ARC_CALLBACK_TOKEN")
+	spvEnabled := getEnv("SPV_ENABLED", "false") == "true" // verify BEEF posted to /api/broadcast
+	spvHeadersFile := os.Getenv("SPV_HEADERS_FILE")        // raw 80-byte headers from genesis
 	feeAddress := getEnv("BITPIC_FEE_ADDRESS", "15q8YQSqUa9uTh6gh4AVixxq29xkpBBP9z")
 	cacheTTLStr := getEnv("IMAGE_CACHE_TTL", "2592000") // 30 days default
 
@@ -37,22 +60,100 @@ func main() {
 	if err != nil {
 		cacheTTL = 2592000 * time.Second // 30 days
 	}
+	ordfsTimeout, err := time.ParseDuration(ordfsTimeoutStr + "s")
+	if err != nil {
+		ordfsTimeout = 10 * time.Second
+	}
+	paymailTimeout, err := time.ParseDuration(paymailTimeoutStr + "s")
+	if err != nil {
+		paymailTimeout = 10 * time.Second
+	}
+	paymailCacheTTL, err := time.ParseDuration(paymailCacheTTLStr + "s")
+	if err != nil {
+		paymailCacheTTL = time.Hour
+	}
 
-	// Initialize Redis
-	redis, err := storage.NewRedisClient(redisURL)
+	// Initialize storage (Redis unless STORAGE_URL says otherwise)
+	store, err := storage.Open(storageURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	log.Println("Connected to storage")
+
+	// Local content store for embedded images (optional)
+	var blobs storage.BlobStore
+	if contentStoreURL != "" {
+		blobs, err = storage.OpenBlobStore(contentStoreURL)
+		if err != nil {
+			log.Fatalf("Failed to open content store: %v", err)
+		}
+		defer blobs.Close()
 	}
-	defer redis.Close()
 
-	log.Println("Connected to Redis")
+	// Initialize the chain source: JungleBus, or a replayed tx dump
+	var source junglebus.ChainSource
+	if replayPath != "" {
+		source, err = junglebus.OpenReplay(replayPath)
+		if err != nil {
+			log.Fatalf("Failed to load replay: %v", err)
+		}
+		log.Printf("Replaying transactions from %s", replayPath)
+	} else {
+		source, err = junglebus.NewJungleBusSource(junglebusURL, subscriptionID)
+		if err != nil {
+			log.Fatalf("Failed to create JungleBus source: %v", err)
+		}
+		log.Printf("Connecting to JungleBus subscription: %s", subscriptionID)
+	}
+
+	subscriber := junglebus.NewSubscriber(source, store)
+	rejects := diagnostics.NewRejectLog(500) // served at /api/diagnostics/rejects
+	subscriber.SetRejectLog(rejects)
+	if blobs != nil {
+		subscriber.SetContentStore(blobs)
+	}
+
+	// Paymail ownership check (OWNER_CHECK=record or require); handles
+	// registered here are checked against the store
+	owners, err := bsvalias.ForPolicy(ownerCheck, paymailTimeout, paymailCacheTTL)
+	if err != nil {
+		log.Fatalf("Invalid OWNER_CHECK: %v", err)
+	}
+	if owners != nil {
+		owners.SetLocal("bitpic.net", store)
+		subscriber.SetOwnerVerifier(owners)
+		log.Printf("Paymail ownership check: %s", ownerCheck)
+	}
+
+	// SPV header store: preloaded from a headers file, then kept current by
+	// the subscriber. Without headers every BEEF submission would fail.
+	var headerStore *headers.Store
+	if spvEnabled {
+		if spvHeadersFile == "" {
+			log.Fatal("SPV_ENABLED requires SPV_HEADERS_FILE")
+		}
+		headerStore = headers.NewStore()
+		if err := headerStore.LoadFile(spvHeadersFile); err != nil {
+			log.Fatalf("Failed to load SPV headers: %v", err)
+		}
+		tip, _ := headerStore.CurrentHeight(context.Background())
+		if tip == 0 {
+			log.Fatalf("No SPV headers in %s", spvHeadersFile)
+		}
+		log.Printf("Loaded SPV headers up to block %d", tip)
+		if err := subscriber.SetHeaderSink(headerStore); err != nil {
+			log.Printf("SPV headers will not follow the chain: %v", err)
+		}
+	}
 
-	// Initialize JungleBus subscriber
-	subscriber := junglebus.NewSubscriber(junglebusURL, subscriptionID, redis)
 	go func() {
 		if err := subscriber.Start(); err != nil {
-			log.Fatalf("JungleBus subscriber failed: %v", err)
+			log.Fatalf("Subscriber failed: %v", err)
 		}
+		// Only a replay runs out; keep serving the index it built.
+		log.Println("Replay finished")
 	}()
 
 	// Create Fiber app
@@ -80,6 +181,10 @@ func main() {
 		KeyGenerator: func(c *fiber.Ctx) string {
 			return c.IP()
 		},
+		// ARC callbacks are authenticated and arrive in bursts from one IP.
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/api/arc/callback"
+		},
 		LimitReached: func(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 				"error": "Rate limit exceeded",
@@ -87,23 +192,96 @@ func main() {
 		},
 	}))
 
+	// Avatar content: the local content store, then each ORDFS server and
+	// content-addressed store in turn
+	counters := &diagnostics.Counters{}
+	var resolvers []content.Resolver
+	if blobs != nil {
+		resolvers = append(resolvers, content.NewBlobs(blobs))
+	}
+	// ORDFS_URL may list several servers, comma-separated; the first is the
+	// one API responses link to.
+	var ordfsURL string
+	for _, u := range strings.Split(ordfsURLs, ",") {
+		u = strings.TrimRight(strings.TrimSpace(u), "/")
+		if u == "" {
+			continue
+		}
+		if ordfsURL == "" {
+			ordfsURL = u
+		}
+		resolvers = append(resolvers, content.NewORDFS(u, ordfsTimeout, content.DefaultMaxBytes))
+	}
+	// CONTENT_HASH_URLS lists content-addressed stores for c:// references,
+	// comma-separated, each with {hash} in place of the SHA-256.
+	for _, u := range strings.Split(contentHashURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			resolvers = append(resolvers, content.NewHashURL(u, ordfsTimeout, content.DefaultMaxBytes))
+		}
+	}
+	resolver := content.NewChain(resolvers...)
+	resolver.OnHashMismatch(func(string, content.Ref) { counters.ContentHashMismatch() })
+
 	// Initialize handlers
-	avatarHandler := handlers.NewAvatarHandler(redis, ordfsURL, cacheTTL)
-	feedHandler := handlers.NewFeedHandler(redis)
-	apiHandler := handlers.NewAPIHandler(redis, ordfsURL)
-	existsHandler := handlers.NewExistsHandler(redis)
-	broadcastHandler := handlers.NewBroadcastHandler(arcURL, redis)
-	statusHandler := handlers.NewStatusHandler(redis, subscriber)
-	paymailHandler := handlers.NewPaymailHandler(redis, feeAddress)
+	avatarHandler := handlers.NewAvatarHandler(store, resolver, blobs, cacheTTL, counters)
+	avatarHandler.SetRedirect(avatarRedirect)
+	// d=<url> defaults (DEFAULT_URL_MODE=redirect or proxy) are limited to
+	// https URLs on the hosts in DEFAULT_URL_HOSTS, comma-separated
+	fallbacks, err := handlers.NewFallbackPolicy(defaultURLMode, strings.Split(defaultURLHosts, ","), ordfsTimeout, content.DefaultMaxBytes)
+	if err != nil {
+		log.Fatalf("Invalid default image URL policy: %v", err)
+	}
+	if fallbacks != nil {
+		avatarHandler.SetFallbackPolicy(fallbacks)
+		log.Printf("Default image URLs: %s to %s", defaultURLMode, defaultURLHosts)
+	} else {
+		// Earlier releases redirected to any d=<url>
+		log.Printf("Default image URLs are off: d=<url> is ignored. Set DEFAULT_URL_MODE=redirect and DEFAULT_URL_HOSTS to keep existing d=https://... embeds working")
+	}
+	feedHandler := handlers.NewFeedHandler(store)
+	apiHandler := handlers.NewAPIHandler(store, ordfsURL)
+	existsHandler := handlers.NewExistsHandler(store)
+	arc := &broadcaster.Arc{
+		ApiUrl: strings.TrimSuffix(strings.TrimSuffix(arcURL, "/"), "/v1") + "/v1",
+		ApiKey: arcAPIKey,
+	}
+	switch {
+	case arcCallbackURL != "" && arcCallbackToken != "":
+		arc.CallbackUrl = &arcCallbackURL
+		arc.CallbackToken = &arcCallbackToken
+	case arcCallbackURL != "":
+		log.Println("ARC_CALLBACK_URL is set without ARC_CALLBACK_TOKEN; ARC callbacks disabled")
+		arcCallbackToken = ""
+	}
+	var tracker headers.ChainTracker
+	if headerStore != nil {
+		tracker = headerStore
+	}
+	broadcastHandler := handlers.NewBroadcastHandler(arc, tracker, store, blobs, owners)
+	broadcastHandler.SetRejectLog(rejects)
+	arcCallbackHandler := handlers.NewARCCallbackHandler(store, arcCallbackToken)
+	statusHandler := handlers.NewStatusHandler(store, subscriber)
+	paymailHandler := handlers.NewPaymailHandler(store, feeAddress)
+	diagnosticsHandler := handlers.NewDiagnosticsHandler(rejects, counters, resolver)
+	validateHandler := handlers.NewValidateHandler(store)
 
 	// Routes
 	app.Get("/health", handlers.Health)
 	app.Get("/u/:paymail", avatarHandler.Handle)
+	app.Get("/pk/:pubkey", avatarHandler.HandlePubKey)
+	app.Get("/c/:outpoint", avatarHandler.HandleContent)
 	app.Get("/api/feed", feedHandler.Handle)
+	app.Get("/api/avatar/pk/:pubkey", apiHandler.HandlePubKey)
 	app.Get("/api/avatar/:paymail", apiHandler.Handle)
+	app.Get("/api/avatar/:paymail/history", apiHandler.History)
 	app.Get("/api/exists/:paymail", existsHandler.Handle)
 	app.Get("/api/status", statusHandler.Handle)
 	app.Post("/api/broadcast", broadcastHandler.Handle)
+	app.Post("/api/validate", validateHandler.Handle)
+	app.Post("/api/arc/callback", arcCallbackHandler.Handle)
+	app.Get("/api/diagnostics/rejects", diagnosticsHandler.Rejects)
+	app.Get("/api/diagnostics/counters", diagnosticsHandler.Counters)
+	app.Get("/api/diagnostics/resolvers", diagnosticsHandler.Resolvers)
 
 	// Paymail routes
 	app.Get("/api/paymail/lookup/:pubkey", paymailHandler.GetByPubkey)
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileBlobStore is a BlobStore in a directory: each blob is a file named by
+// its hash, fanned out by the first two hex digits (ab/abcdef…).
+type FileBlobStore struct {
+	dir string
+}
+
+var _ BlobStore = (*FileBlobStore)(nil)
+
+// NewFileBlobStore opens (creating if needed) a blob store in dir
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if dir == "" {
+		return nil, errors.New("content store directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create content store: %w", err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (f *FileBlobStore) path(hash string) string {
+	return filepath.Join(f.dir, hash[:2], hash)
+}
+
+// PutBlob writes data under its hash. The file is written under a temporary
+// name and renamed, so a reader never sees a partial blob.
+func (f *FileBlobStore) PutBlob(data []byte) (string, error) {
+	hash := blobHash(data)
+	path := f.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return hash, nil
+}
+
+// GetBlob reads the blob stored under hash
+func (f *FileBlobStore) GetBlob(hash string) ([]byte, error) {
+	hash = strings.ToLower(hash)
+	if !validBlobHash(hash) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(f.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return data, nil
+}
+
+// Close is a no-op
+func (f *FileBlobStore) Close() error { return nil }
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBlobStore is a BlobStore in Redis: one string per blob at
+// bitpic:blob:<hash>, without expiry.
+type RedisBlobStore struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+var _ BlobStore = (*RedisBlobStore)(nil)
+
+// NewRedisBlobStore connects to the Redis server at redisURL
+func NewRedisBlobStore(redisURL string) (*RedisBlobStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx := context.Background()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisBlobStore{
+		client: client,
+		ctx:    ctx,
+	}, nil
+}
+
+// PutBlob stores data under its hash
+func (r *RedisBlobStore) PutBlob(data []byte) (string, error) {
+	hash := blobHash(data)
+	if err := r.client.SetNX(r.ctx, "bitpic:blob:"+hash, data, 0).Err(); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return hash, nil
+}
+
+// GetBlob returns the bytes stored under hash
+func (r *RedisBlobStore) GetBlob(hash string) ([]byte, error) {
+	hash = strings.ToLower(hash)
+	if !validBlobHash(hash) {
+		return nil, nil
+	}
+	data, err := r.client.Get(r.ctx, "bitpic:blob:"+hash).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return data, nil
+}
+
+// Close closes the Redis connection
+func (r *RedisBlobStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testBlobStores(t *testing.T) map[string]BlobStore {
+	t.Helper()
+	file, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore: %v", err)
+	}
+	redis, err := NewRedisBlobStore("redis://" + miniredis.RunT(t).Addr())
+	if err != nil {
+		t.Fatalf("NewRedisBlobStore: %v", err)
+	}
+	t.Cleanup(func() { redis.Close() })
+	return map[string]BlobStore{
+		"memory": NewMemoryBlobStore(),
+		"file":   file,
+		"redis":  redis,
+	}
+}
+
+func TestBlobStores(t *testing.T) {
+	data := []byte("\x89PNG\r\n\x1a\navatar")
+	hash := blobHash(data)
+
+	for name, blobs := range testBlobStores(t) {
+		t.Run(name, func(t *testing.T) {
+			got, err := blobs.PutBlob(data)
+			if err != nil || got != hash {
+				t.Fatalf("PutBlob = %q, %v; want %q", got, err, hash)
+			}
+			// Storing the same bytes again is a no-op
+			if got, err := blobs.PutBlob(data); err != nil || got != hash {
+				t.Fatalf("second PutBlob = %q, %v; want %q", got, err, hash)
+			}
+
+			for _, key := range []string{hash, strings.ToUpper(hash)} {
+				if stored, err := blobs.GetBlob(key); err != nil || !bytes.Equal(stored, data) {
+					t.Errorf("GetBlob(%s) = %q, %v; want the blob", key, stored, err)
+				}
+			}
+
+			for _, key := range []string{
+				blobHash([]byte("missing")),
+				"",
+				hash[:63],
+				hash + "00",
+				strings.Repeat("g", 64),
+				"../" + hash[3:],
+				hash[:2] + "/" + hash[3:],
+			} {
+				if stored, err := blobs.GetBlob(key); err != nil || stored != nil {
+					t.Errorf("GetBlob(%q) = %q, %v; want nil, nil", key, stored, err)
+				}
+			}
+		})
+	}
+}
+
+// Blobs are written under a temporary name and renamed into place: nothing
+// but the blob itself is left behind, even with writers racing on it.
+func TestFileBlobStoreRename(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("avatar"), 4096)
+	hash := blobHash(data)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := blobs.PutBlob(data); err != nil {
+				t.Errorf("PutBlob: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(filepath.Join(dir, hash[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != hash {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("blob directory holds %v, want just %s", names, hash)
+	}
+	if stored, err := os.ReadFile(filepath.Join(dir, hash[:2], hash)); err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("blob file = %d bytes, %v; want the blob", len(stored), err)
+	}
+
+	// A temp file left by an interrupted write is not mistaken for the blob
+	other := []byte("other avatar")
+	otherHash := blobHash(other)
+	if err := os.MkdirAll(filepath.Join(dir, otherHash[:2]), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, otherHash[:2], otherHash+".tmp123"), other[:5], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := blobs.GetBlob(otherHash); err != nil || stored != nil {
+		t.Errorf("GetBlob before put = %q, %v; want nil, nil", stored, err)
+	}
+	if _, err := blobs.PutBlob(other); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := blobs.GetBlob(otherHash); err != nil || !bytes.Equal(stored, other) {
+		t.Errorf("GetBlob = %q, %v; want the blob", stored, err)
+	}
+}
+
+func TestOpenBlobStore(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"memory://":             true,
+		"file://" + t.TempDir(): true,
+		"file://":               false,
+		"s3://bucket":           false,
+		"redis://127.0.0.1:1":   false,
+	} {
+		blobs, err := OpenBlobStore(url)
+		if (err == nil) != ok {
+			t.Errorf("OpenBlobStore(%q) error = %v, want ok = %v", url, err, ok)
+		}
+		if err == nil {
+			blobs.Close()
+		}
+	}
+}
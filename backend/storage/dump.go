@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// dumpBlocks is how many recent block hashes a dump carries: enough for the
+// reorg checks, which only look at recent blocks.
+const dumpBlocks = 1000
+
+// DumpRecord is one line of an index dump. Exactly one field is set.
+type DumpRecord struct {
+	LastBlock uint64      `json:"lastBlock,omitempty"` // sync cursor
+	Block     *BlockRef   `json:"block,omitempty"`     // a recorded block hash
+	Avatar    *AvatarData `json:"avatar,omitempty"`    // an avatar history record
+}
+
+// Export writes the avatar index in store to w as JSON lines: the sync
+// cursor, the recent block hashes, then every paymail's avatar history. It
+// returns the number of avatar records written.
+func Export(store Store, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+
+	lastBlock, err := store.GetLastBlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last block: %w", err)
+	}
+	if lastBlock > 0 {
+		if err := enc.Encode(DumpRecord{LastBlock: lastBlock}); err != nil {
+			return 0, fmt.Errorf("failed to write dump: %w", err)
+		}
+	}
+
+	blocks, err := store.GetRecentBlocks(dumpBlocks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get recent blocks: %w", err)
+	}
+	for i := range blocks {
+		if err := enc.Encode(DumpRecord{Block: &blocks[i]}); err != nil {
+			return 0, fmt.Errorf("failed to write dump: %w", err)
+		}
+	}
+
+	total, err := store.GetTotalAvatars()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total avatars: %w", err)
+	}
+	paymails, err := store.GetRecentPaymails(total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list paymails: %w", err)
+	}
+
+	var n int
+	for _, paymail := range paymails {
+		cursor := ""
+		for {
+			items, next, err := store.GetAvatarHistory(paymail, cursor, 100)
+			if err != nil {
+				return n, fmt.Errorf("failed to get history for %s: %w", paymail, err)
+			}
+			for i := range items {
+				if err := enc.Encode(DumpRecord{Avatar: &items[i]}); err != nil {
+					return n, fmt.Errorf("failed to write dump: %w", err)
+				}
+				n++
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+	return n, nil
+}
+
+// Import loads a dump written by Export into store. Records merge with what
+// the store already holds: avatars by newest-wins, the sync cursor only if it
+// is ahead. It returns the number of avatar records read.
+//
+// Export writes each paymail's history newest first; Import replays it oldest
+// first, the order the indexer saw it, so ties on timestamp or txid resolve
+// the same way they did live.
+func Import(store Store, r io.Reader) (int, error) {
+	current, err := store.GetLastBlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last block: %w", err)
+	}
+
+	var (
+		n       int
+		pending []*AvatarData // the current paymail's history
+	)
+	flush := func() error {
+		sort.SliceStable(pending, func(i, j int) bool {
+			return historyCursor(pending[i]) < historyCursor(pending[j])
+		})
+		for _, data := range pending {
+			if err := store.SetAvatar(data); err != nil {
+				return fmt.Errorf("failed to store avatar for %s: %w", data.Paymail, err)
+			}
+			n++
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var rec DumpRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return n, flush()
+			}
+			return n, fmt.Errorf("failed to read dump record %d: %w", line, err)
+		}
+
+		switch {
+		case rec.Avatar != nil:
+			if len(pending) > 0 && pending[0].Paymail != rec.Avatar.Paymail {
+				if err := flush(); err != nil {
+					return n, err
+				}
+			}
+			pending = append(pending, rec.Avatar)
+		case rec.Block != nil:
+			if err := store.SetBlockHash(rec.Block.Height, rec.Block.Hash); err != nil {
+				return n, fmt.Errorf("failed to store block %d: %w", rec.Block.Height, err)
+			}
+		case rec.LastBlock > current:
+			if err := store.SetLastBlock(rec.LastBlock); err != nil {
+				return n, fmt.Errorf("failed to set last block: %w", err)
+			}
+			current = rec.LastBlock
+		}
+	}
+}
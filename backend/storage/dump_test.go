@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	txA := strings.Repeat("a", 64)
+	txB := strings.Repeat("b", 64)
+	txC := strings.Repeat("c", 64)
+	txD := strings.Repeat("d", 64)
+	records := []AvatarData{
+		{Outpoint: txA + "_0", TxID: txA, Paymail: "alice@example.com", Timestamp: 100, Confirmed: true, BlockHeight: 10, BlockHash: "h10"},
+		{Outpoint: txB + "_0", TxID: txB, Paymail: "alice@example.com", Timestamp: 200},
+		// Two outputs of one tx: the later output is current
+		{Outpoint: txC + "_0", TxID: txC, Paymail: "bob@example.com", Timestamp: 300},
+		{Outpoint: txC + "_1", TxID: txC, Paymail: "bob@example.com", Timestamp: 300, IsRef: true, RefOrigin: txA + "_1"},
+		// Two txs at the same timestamp: the one indexed last is current
+		{Outpoint: txC + "_2", TxID: txC, Paymail: "carol@example.com", Timestamp: 400},
+		{Outpoint: txD + "_0", TxID: txD, Paymail: "carol@example.com", Timestamp: 400},
+	}
+
+	src := NewMemoryStore()
+	for i := range records {
+		rec := records[i]
+		if err := src.SetAvatar(&rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.SetBlockHash(10, "h10")
+	src.SetBlockHash(11, "h11")
+	src.SetLastBlock(11)
+
+	var dump bytes.Buffer
+	if n, err := Export(src, &dump); err != nil || n != len(records) {
+		t.Fatalf("Export = %d, %v; want %d records", n, err, len(records))
+	}
+
+	for name, dst := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if n, err := Import(dst, bytes.NewReader(dump.Bytes())); err != nil || n != len(records) {
+				t.Fatalf("Import = %d, %v; want %d records", n, err, len(records))
+			}
+
+			for _, paymail := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+				want, _ := src.GetAvatarData(paymail)
+				got, err := dst.GetAvatarData(paymail)
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("%s current = %+v, %v; want %+v", paymail, got, err, want)
+				}
+				wantHistory, _, _ := src.GetAvatarHistory(paymail, "", 10)
+				gotHistory, _, err := dst.GetAvatarHistory(paymail, "", 10)
+				if err != nil || !reflect.DeepEqual(gotHistory, wantHistory) {
+					t.Errorf("%s history = %+v, %v; want %+v", paymail, gotHistory, err, wantHistory)
+				}
+			}
+			if last, _ := dst.GetLastBlock(); last != 11 {
+				t.Errorf("last block = %d, want 11", last)
+			}
+			if hash, _ := dst.GetBlockHash(10); hash != "h10" {
+				t.Errorf("block 10 hash = %q, want h10", hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store. Nothing survives a restart; it exists
+// for tests and for running the indexer without any external service.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	avatars   map[string]AvatarData
+	history   map[string]map[string]AvatarData // paymail -> outpoint -> record
+	images    map[string]cachedImage
+	paymails  map[string]PaymailData
+	paidTxids map[string]bool
+	lastBlock uint64
+	blocks    map[uint64]string
+}
+
+type cachedImage struct {
+	data      []byte
+	expiresAt time.Time // zero means no expiry
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		avatars:   make(map[string]AvatarData),
+		history:   make(map[string]map[string]AvatarData),
+		images:    make(map[string]cachedImage),
+		paymails:  make(map[string]PaymailData),
+		paidTxids: make(map[string]bool),
+		blocks:    make(map[uint64]string),
+	}
+}
+
+// SetAvatar stores avatar data for a paymail
+func (m *MemoryStore) SetAvatar(data *AvatarData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.history[data.Paymail] == nil {
+		m.history[data.Paymail] = make(map[string]AvatarData)
+	}
+	m.history[data.Paymail][data.Outpoint] = *data
+
+	if existing, ok := m.avatars[data.Paymail]; ok && !NewestWins(&existing, data.TxID, data.Timestamp) {
+		return nil
+	}
+	m.avatars[data.Paymail] = *data
+	return nil
+}
+
+// GetAvatarHistory returns a page of a paymail's avatar records, newest first,
+// starting after cursor ("" for the first page). The returned cursor is empty
+// on the last page.
+func (m *MemoryStore) GetAvatarHistory(paymail, cursor string, limit int64) ([]AvatarData, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var records []AvatarData
+	for _, data := range m.history[paymail] {
+		if cursor == "" || historyCursor(&data) < cursor {
+			records = append(records, data)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return historyCursor(&records[i]) > historyCursor(&records[j])
+	})
+
+	if int64(len(records)) <= limit {
+		return records, "", nil
+	}
+	records = records[:limit]
+	return records, historyCursor(&records[limit-1]), nil
+}
+
+// GetAvatarsByTxID returns the avatar records a transaction created, in
+// outpoint order
+func (m *MemoryStore) GetAvatarsByTxID(txid string) ([]AvatarData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []AvatarData
+	for _, records := range m.history {
+		for _, data := range records {
+			if data.TxID == txid {
+				items = append(items, data)
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Outpoint < items[j].Outpoint })
+	return items, nil
+}
+
+// GetAvatarPaymailsByPubKey returns every paymail pubKey has signed an avatar for
+func (m *MemoryStore) GetAvatarPaymailsByPubKey(pubKey string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var paymails []string
+	for paymail, records := range m.history {
+		for _, data := range records {
+			if data.PubKey == pubKey {
+				paymails = append(paymails, paymail)
+				break
+			}
+		}
+	}
+	sort.Strings(paymails)
+	return paymails, nil
+}
+
+// RemoveAvatar deletes an avatar record. If it was the paymail's current
+// avatar, the newest remaining record takes its place. It returns the
+// paymail's current avatar afterwards, or nil if none is left.
+func (m *MemoryStore) RemoveAvatar(paymail, outpoint string) (*AvatarData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.history[paymail], outpoint)
+	if current, ok := m.avatars[paymail]; ok && current.Outpoint == outpoint {
+		delete(m.avatars, paymail)
+		var newest *AvatarData
+		for _, data := range m.history[paymail] {
+			if newest == nil || historyCursor(&data) > historyCursor(newest) {
+				newest = &data
+			}
+		}
+		if newest != nil {
+			m.avatars[paymail] = *newest
+		}
+	}
+	if len(m.history[paymail]) == 0 {
+		delete(m.history, paymail)
+	}
+
+	data, ok := m.avatars[paymail]
+	if !ok {
+		return nil, nil
+	}
+	return &data, nil
+}
+
+// GetAvatar retrieves the current avatar outpoint for a paymail
+func (m *MemoryStore) GetAvatar(paymail string) (string, error) {
+	data, _ := m.GetAvatarData(paymail)
+	if data == nil {
+		return "", nil
+	}
+	return data.ContentOutpoint(), nil
+}
+
+// GetAvatarData retrieves the full avatar data for a paymail
+func (m *MemoryStore) GetAvatarData(paymail string) (*AvatarData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.avatars[paymail]
+	if !ok {
+		return nil, nil
+	}
+	return &data, nil
+}
+
+// Exists checks if an avatar exists for a paymail
+func (m *MemoryStore) Exists(paymail string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.avatars[paymail]
+	return ok, nil
+}
+
+// GetTotalAvatars returns the total count of avatars
+func (m *MemoryStore) GetTotalAvatars() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int64(len(m.avatars)), nil
+}
+
+// feedOrder returns every avatar newest first, matching the Redis feed ZSET
+// (score = timestamp, ties broken by reverse member order).
+func (m *MemoryStore) feedOrder() []AvatarData {
+	items := make([]AvatarData, 0, len(m.avatars))
+	for _, data := range m.avatars {
+		items = append(items, data)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Timestamp != items[j].Timestamp {
+			return items[i].Timestamp > items[j].Timestamp
+		}
+		return items[i].Paymail > items[j].Paymail
+	})
+	return items
+}
+
+// GetRecentPaymails returns the most recent paymails from the feed (newest first).
+func (m *MemoryStore) GetRecentPaymails(limit int64) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var paymails []string
+	for _, data := range m.feedOrder() {
+		if int64(len(paymails)) >= limit {
+			break
+		}
+		paymails = append(paymails, data.Paymail)
+	}
+	return paymails, nil
+}
+
+// GetFeed retrieves paginated feed items
+func (m *MemoryStore) GetFeed(offset, limit int64, ordfsBaseURL string) ([]FeedItem, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := m.feedOrder()
+	total := int64(len(all))
+
+	var items []FeedItem
+	for i := offset; i < total && i < offset+limit; i++ {
+		items = append(items, feedItem(&all[i], ordfsBaseURL))
+	}
+	return items, total, nil
+}
+
+// CacheImage stores an image in cache with TTL
+func (m *MemoryStore) CacheImage(outpoint string, data []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := cachedImage{data: append([]byte(nil), data...)}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	m.images[outpoint] = entry
+	return nil
+}
+
+// GetCachedImage retrieves a cached image
+func (m *MemoryStore) GetCachedImage(outpoint string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.images[outpoint]
+	if !ok {
+		return nil, nil
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(m.images, outpoint)
+		return nil, nil
+	}
+	return entry.data, nil
+}
+
+// SetLastBlock stores the last processed block height
+func (m *MemoryStore) SetLastBlock(height uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastBlock = height
+	return nil
+}
+
+// GetLastBlock retrieves the last processed block height
+func (m *MemoryStore) GetLastBlock() (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastBlock, nil
+}
+
+// SetBlockHash records the hash of a processed block
+func (m *MemoryStore) SetBlockHash(height uint64, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks[height] = hash
+	return nil
+}
+
+// GetBlockHash returns the recorded hash for a height, or "" if none
+func (m *MemoryStore) GetBlockHash(height uint64) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.blocks[height], nil
+}
+
+// GetRecentBlocks returns the highest recorded blocks, highest first
+func (m *MemoryStore) GetRecentBlocks(limit int64) ([]BlockRef, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	blocks := make([]BlockRef, 0, len(m.blocks))
+	for height, hash := range m.blocks {
+		blocks = append(blocks, BlockRef{Height: height, Hash: hash})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Height > blocks[j].Height })
+	if int64(len(blocks)) > limit {
+		blocks = blocks[:limit]
+	}
+	return blocks, nil
+}
+
+// RollbackBlocks unconfirms every avatar record confirmed at or above
+// fromHeight, forgets the recorded blocks from fromHeight up and moves the
+// sync cursor back below fromHeight. It returns the unconfirmed records.
+func (m *MemoryStore) RollbackBlocks(fromHeight uint64) ([]AvatarData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var affected []AvatarData
+	for paymail, records := range m.history {
+		for outpoint, data := range records {
+			if !data.Confirmed || data.BlockHeight < fromHeight {
+				continue
+			}
+			unconfirm(&data)
+			records[outpoint] = data
+			affected = append(affected, data)
+
+			if current, ok := m.avatars[paymail]; ok && current.Outpoint == outpoint {
+				m.avatars[paymail] = data
+			}
+		}
+	}
+
+	for height := range m.blocks {
+		if height >= fromHeight {
+			delete(m.blocks, height)
+		}
+	}
+	if m.lastBlock >= fromHeight {
+		m.lastBlock = blockBefore(fromHeight)
+	}
+	return affected, nil
+}
+
+// ClaimPaymentTxid records a fee-payment txid, returning true only the first
+// time it is seen.
+func (m *MemoryStore) ClaimPaymentTxid(txid string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paidTxids[txid] {
+		return false, nil
+	}
+	m.paidTxids[txid] = true
+	return true, nil
+}
+
+// SetPaymail stores a paymail record
+func (m *MemoryStore) SetPaymail(data *PaymailData) error {
+	if data.CreatedAt == 0 {
+		data.CreatedAt = time.Now().Unix()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paymails[data.Handle] = *data
+	return nil
+}
+
+// GetPaymail retrieves a paymail record
+func (m *MemoryStore) GetPaymail(handle string) (*PaymailData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.paymails[handle]
+	if !ok {
+		return nil, nil
+	}
+	return &data, nil
+}
+
+// GetPaymailByPubkey looks up a paymail by identity pubkey
+func (m *MemoryStore) GetPaymailByPubkey(pubkey string) (*PaymailData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, data := range m.paymails {
+		if strings.EqualFold(data.IdentityPubkey, pubkey) {
+			return &data, nil
+		}
+	}
+	return nil, nil
+}
+
+// Close is a no-op for the in-memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,26 +18,7 @@ type RedisClient struct {
 	ctx    context.Context
 }
 
-// AvatarData represents avatar metadata stored in Redis
-type AvatarData struct {
-	Outpoint  string `json:"outpoint"`
-	Timestamp int64  `json:"timestamp"`
-	Paymail   string `json:"paymail"`
-	TxID      string `json:"txid"`
-	Confirmed bool   `json:"confirmed"`
-	IsRef     bool   `json:"isRef,omitempty"`     // True if this points to an ordinal
-	RefOrigin string `json:"refOrigin,omitempty"` // The ordinal origin being referenced
-}
-
-// FeedItem represents an item in the feed
-type FeedItem struct {
-	Paymail   string `json:"paymail"`
-	Outpoint  string `json:"outpoint"`
-	Timestamp int64  `json:"timestamp"`
-	URL       string `json:"url"`
-	TxID      string `json:"txid"`
-	Confirmed bool   `json:"confirmed"`
-}
+var _ Store = (*RedisClient)(nil)
 
 // NewRedisClient creates a new Redis client connection
 func NewRedisClient(redisURL string) (*RedisClient, error) {
@@ -58,51 +41,88 @@ func NewRedisClient(redisURL string) (*RedisClient, error) {
 	}, nil
 }
 
-// SetAvatar stores avatar data for a paymail
-func (r *RedisClient) SetAvatar(paymail, outpoint, txid string, timestamp int64, confirmed bool, isRef bool, refOrigin string) error {
-	// Newest-wins: a user's latest BitPic record is their avatar. Don't let an
-	// older record (e.g. a historical re-sync) clobber a newer one. Updates to
-	// the same tx (mempool -> confirmed) are always allowed.
-	if existing, _ := r.GetAvatarData(paymail); existing != nil {
-		if existing.TxID != txid && timestamp < existing.Timestamp {
-			return nil
-		}
-	}
-
-	data := AvatarData{
-		Outpoint:  outpoint,
-		Timestamp: timestamp,
-		Paymail:   paymail,
-		TxID:      txid,
-		Confirmed: confirmed,
-		IsRef:     isRef,
-		RefOrigin: refOrigin,
-	}
+// setAvatarScript records an avatar in the paymail's history and, if it wins
+// newest-wins (see NewestWins), makes it the current avatar. Running it as one
+// script makes the compare-and-write atomic: concurrent writers (a broadcast
+// and a JungleBus mempool event, say) can't interleave so that an older record
+// wins or the current and meta keys disagree.
+//
+// History entries are keyed by outpoint, so a re-index of the same output
+// (mempool -> confirmed) replaces its entry rather than adding another.
+// bitpic:history:<paymail> is a ZSET with every score 0, ordered by member
+// (historyCursor); bitpic:historydata:<paymail> maps outpoint -> AvatarData.
+// bitpic:confirmed indexes confirmed records by block height (member
+// "<outpoint>:<paymail>") so a reorg can find them; bitpic:tx:<txid> is a SET
+// of the same "<outpoint>:<paymail>" for every record a transaction created
+// (a batch tx may set several avatars) so an ARC callback can.
+// bitpic:pubkey:<pubkey> is the SET of paymails with a record the key signed
+// (removeAvatarScript takes a paymail out with its last such record).
+//
+// KEYS: current, meta, feed, history index, history data, confirmed index, tx index, pubkey index
+// ARGV: avatar json, paymail, outpoint, txid, timestamp, history sort key, block height (0 = unconfirmed), pubkey ("" = none)
+var setAvatarScript = redis.NewScript(`
+local outpoint, txid, ts = ARGV[3], ARGV[4], tonumber(ARGV[5])
+
+local prev = redis.call('HGET', KEYS[5], outpoint)
+if prev then
+	local old = cjson.decode(prev)
+	if tonumber(old.timestamp) ~= ts then
+		redis.call('ZREM', KEYS[4], string.format('%019d:%s', old.timestamp, outpoint))
+	end
+end
+redis.call('HSET', KEYS[5], outpoint, ARGV[1])
+redis.call('ZADD', KEYS[4], 0, ARGV[6])
+
+local confirmedMember = outpoint .. ':' .. ARGV[2]
+redis.call('SADD', KEYS[7], confirmedMember)
+if tonumber(ARGV[7]) > 0 then
+	redis.call('ZADD', KEYS[6], ARGV[7], confirmedMember)
+else
+	redis.call('ZREM', KEYS[6], confirmedMember)
+end
+if ARGV[8] ~= '' then
+	redis.call('SADD', KEYS[8], ARGV[2])
+end
+
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	local existing = cjson.decode(cur)
+	if existing.txid ~= txid and ts < tonumber(existing.timestamp) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('SET', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ts, ARGV[2])
+return 1
+`)
 
+// SetAvatar stores avatar data for a paymail
+func (r *RedisClient) SetAvatar(data *AvatarData) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal avatar data: %w", err)
 	}
 
-	// Store current avatar
-	key := fmt.Sprintf("bitpic:current:%s", paymail)
-	if err := r.client.Set(r.ctx, key, jsonData, 0).Err(); err != nil {
-		return fmt.Errorf("failed to set avatar: %w", err)
+	var blockHeight uint64
+	if data.Confirmed {
+		blockHeight = data.BlockHeight
 	}
 
-	// Add to feed (sorted set by timestamp)
-	feedKey := "bitpic:feed"
-	if err := r.client.ZAdd(r.ctx, feedKey, redis.Z{
-		Score:  float64(timestamp),
-		Member: paymail,
-	}).Err(); err != nil {
-		return fmt.Errorf("failed to add to feed: %w", err)
+	keys := []string{
+		fmt.Sprintf("bitpic:current:%s", data.Paymail),
+		fmt.Sprintf("bitpic:meta:%s", data.Paymail),
+		"bitpic:feed",
+		fmt.Sprintf("bitpic:history:%s", data.Paymail),
+		fmt.Sprintf("bitpic:historydata:%s", data.Paymail),
+		"bitpic:confirmed",
+		fmt.Sprintf("bitpic:tx:%s", data.TxID),
+		fmt.Sprintf("bitpic:pubkey:%s", data.PubKey),
 	}
-
-	// Store metadata for feed lookup
-	metaKey := fmt.Sprintf("bitpic:meta:%s", paymail)
-	if err := r.client.Set(r.ctx, metaKey, jsonData, 0).Err(); err != nil {
-		return fmt.Errorf("failed to set metadata: %w", err)
+	if err := setAvatarScript.Run(r.ctx, r.client, keys,
+		jsonData, data.Paymail, data.Outpoint, data.TxID, data.Timestamp, historyCursor(data), blockHeight, data.PubKey,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to set avatar: %w", err)
 	}
 
 	return nil
@@ -125,10 +145,7 @@ func (r *RedisClient) GetAvatar(paymail string) (string, error) {
 	}
 
 	// References resolve to the referenced content, not the BitPic tx output.
-	if data.IsRef && data.RefOrigin != "" {
-		return data.RefOrigin, nil
-	}
-	return data.Outpoint, nil
+	return data.ContentOutpoint(), nil
 }
 
 // GetAvatarData retrieves the full avatar data for a paymail
@@ -150,6 +167,199 @@ func (r *RedisClient) GetAvatarData(paymail string) (*AvatarData, error) {
 	return &data, nil
 }
 
+// GetAvatarHistory returns a page of a paymail's avatar records, newest first,
+// starting after cursor ("" for the first page). The returned cursor is empty
+// on the last page.
+func (r *RedisClient) GetAvatarHistory(paymail, cursor string, limit int64) ([]AvatarData, string, error) {
+	indexKey := fmt.Sprintf("bitpic:history:%s", paymail)
+	dataKey := fmt.Sprintf("bitpic:historydata:%s", paymail)
+
+	max := "+"
+	if cursor != "" {
+		max = "(" + cursor
+	}
+	// One extra to learn whether there is a next page.
+	members, err := r.client.ZRevRangeByLex(r.ctx, indexKey, &redis.ZRangeBy{
+		Max:   max,
+		Min:   "-",
+		Count: limit + 1,
+	}).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get avatar history: %w", err)
+	}
+	if len(members) == 0 {
+		return nil, "", nil
+	}
+
+	more := int64(len(members)) > limit
+	if more {
+		members = members[:limit]
+	}
+
+	outpoints := make([]string, len(members))
+	for i, member := range members {
+		outpoints[i] = member[strings.Index(member, ":")+1:]
+	}
+	values, err := r.client.HMGet(r.ctx, dataKey, outpoints...).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get avatar history: %w", err)
+	}
+
+	var items []AvatarData
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var data AvatarData
+		if err := json.Unmarshal([]byte(str), &data); err != nil {
+			continue
+		}
+		items = append(items, data)
+	}
+
+	next := ""
+	if more {
+		next = members[len(members)-1]
+	}
+	return items, next, nil
+}
+
+// GetAvatarsByTxID returns the avatar records a transaction created, in
+// outpoint order
+func (r *RedisClient) GetAvatarsByTxID(txid string) ([]AvatarData, error) {
+	members, err := r.client.SMembers(r.ctx, fmt.Sprintf("bitpic:tx:%s", txid)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatars by txid: %w", err)
+	}
+	sort.Strings(members)
+
+	var items []AvatarData
+	for _, member := range members {
+		outpoint, paymail, ok := strings.Cut(member, ":")
+		if !ok {
+			continue
+		}
+		result, err := r.client.HGet(r.ctx, fmt.Sprintf("bitpic:historydata:%s", paymail), outpoint).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get avatars by txid: %w", err)
+		}
+		var data AvatarData
+		if err := json.Unmarshal([]byte(result), &data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal avatar data: %w", err)
+		}
+		items = append(items, data)
+	}
+	return items, nil
+}
+
+// GetAvatarPaymailsByPubKey returns every paymail with an avatar record signed
+// with pubKey
+func (r *RedisClient) GetAvatarPaymailsByPubKey(pubKey string) ([]string, error) {
+	paymails, err := r.client.SMembers(r.ctx, fmt.Sprintf("bitpic:pubkey:%s", pubKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get paymails by pubkey: %w", err)
+	}
+	sort.Strings(paymails)
+	return paymails, nil
+}
+
+// removeAvatarScript deletes one avatar record from every index. If it was
+// the current avatar, the newest remaining history record takes its place (or
+// the paymail leaves the feed if none is left). Returns the current avatar
+// afterwards, or nil.
+//
+// The paymail leaves bitpic:pubkey:<pubkey> once none of its remaining
+// history records was signed with the removed record's key.
+//
+// KEYS: current, meta, feed, history index, history data, confirmed index, tx index, pubkey index
+// ARGV: paymail, outpoint, pubkey ("" = none)
+var removeAvatarScript = redis.NewScript(`
+local paymail, outpoint = ARGV[1], ARGV[2]
+
+local rec = redis.call('HGET', KEYS[5], outpoint)
+if rec then
+	local data = cjson.decode(rec)
+	redis.call('HDEL', KEYS[5], outpoint)
+	redis.call('ZREM', KEYS[4], string.format('%019d:%s', data.timestamp, outpoint))
+	redis.call('ZREM', KEYS[6], outpoint .. ':' .. paymail)
+	redis.call('SREM', KEYS[7], outpoint .. ':' .. paymail)
+
+	if ARGV[3] ~= '' and data.pubkey == ARGV[3] then
+		local signed = false
+		for _, other in ipairs(redis.call('HVALS', KEYS[5])) do
+			if cjson.decode(other).pubkey == ARGV[3] then
+				signed = true
+				break
+			end
+		end
+		if not signed then
+			redis.call('SREM', KEYS[8], paymail)
+		end
+	end
+end
+
+local cur = redis.call('GET', KEYS[1])
+if cur and cjson.decode(cur).outpoint == outpoint then
+	local top = redis.call('ZREVRANGEBYLEX', KEYS[4], '+', '-', 'LIMIT', 0, 1)
+	local prev = top[1] and redis.call('HGET', KEYS[5], string.sub(top[1], 21))
+	if not prev then
+		redis.call('DEL', KEYS[1], KEYS[2])
+		redis.call('ZREM', KEYS[3], paymail)
+		return false
+	end
+	redis.call('SET', KEYS[1], prev)
+	redis.call('SET', KEYS[2], prev)
+	redis.call('ZADD', KEYS[3], cjson.decode(prev).timestamp, paymail)
+	return prev
+end
+return cur
+`)
+
+// RemoveAvatar deletes an avatar record. If it was the paymail's current
+// avatar, the newest remaining record takes its place. It returns the
+// paymail's current avatar afterwards, or nil if none is left.
+func (r *RedisClient) RemoveAvatar(paymail, outpoint string) (*AvatarData, error) {
+	// The record's key names its pubkey index; an outpoint's record is
+	// always signed with the same key, so reading it first is safe.
+	historyData := fmt.Sprintf("bitpic:historydata:%s", paymail)
+	var pubKey string
+	if rec, err := r.client.HGet(r.ctx, historyData, outpoint).Result(); err == nil {
+		var old AvatarData
+		if json.Unmarshal([]byte(rec), &old) == nil {
+			pubKey = old.PubKey
+		}
+	} else if err != redis.Nil {
+		return nil, fmt.Errorf("failed to remove avatar: %w", err)
+	}
+
+	keys := []string{
+		fmt.Sprintf("bitpic:current:%s", paymail),
+		fmt.Sprintf("bitpic:meta:%s", paymail),
+		"bitpic:feed",
+		fmt.Sprintf("bitpic:history:%s", paymail),
+		historyData,
+		"bitpic:confirmed",
+		fmt.Sprintf("bitpic:tx:%s", outpointTxID(outpoint)),
+		fmt.Sprintf("bitpic:pubkey:%s", pubKey),
+	}
+	result, err := removeAvatarScript.Run(r.ctx, r.client, keys, paymail, outpoint, pubKey).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove avatar: %w", err)
+	}
+	var data AvatarData
+	if err := json.Unmarshal([]byte(result), &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal avatar data: %w", err)
+	}
+	return &data, nil
+}
+
 // GetRecentPaymails returns the most recent paymails from the feed (newest first).
 func (r *RedisClient) GetRecentPaymails(limit int64) ([]string, error) {
 	return r.client.ZRevRange(r.ctx, "bitpic:feed", 0, limit-1).Result()
@@ -186,19 +396,7 @@ func (r *RedisClient) GetFeed(offset, limit int64, ordfsBaseURL string) ([]FeedI
 
 		// For references, the image lives at the referenced outpoint, not the
 		// BitPic tx output.
-		displayOutpoint := data.Outpoint
-		if data.IsRef && data.RefOrigin != "" {
-			displayOutpoint = data.RefOrigin
-		}
-		url := fmt.Sprintf("%s/%s", ordfsBaseURL, displayOutpoint)
-		items = append(items, FeedItem{
-			Paymail:   data.Paymail,
-			Outpoint:  displayOutpoint,
-			Timestamp: data.Timestamp,
-			URL:       url,
-			TxID:      data.TxID,
-			Confirmed: data.Confirmed,
-		})
+		items = append(items, feedItem(&data, ordfsBaseURL))
 	}
 
 	return items, total, nil
@@ -258,6 +456,124 @@ func (r *RedisClient) GetLastBlock() (uint64, error) {
 	return result, nil
 }
 
+// SetBlockHash records the hash of a processed block. bitpic:sync:blocks is a
+// ZSET of block hashes scored by height.
+func (r *RedisClient) SetBlockHash(height uint64, hash string) error {
+	key := "bitpic:sync:blocks"
+	_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		h := strconv.FormatUint(height, 10)
+		pipe.ZRemRangeByScore(r.ctx, key, h, h)
+		pipe.ZAdd(r.ctx, key, redis.Z{Score: float64(height), Member: hash})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set block hash: %w", err)
+	}
+	return nil
+}
+
+// GetBlockHash returns the recorded hash for a height, or "" if none
+func (r *RedisClient) GetBlockHash(height uint64) (string, error) {
+	h := strconv.FormatUint(height, 10)
+	hashes, err := r.client.ZRangeByScore(r.ctx, "bitpic:sync:blocks", &redis.ZRangeBy{Min: h, Max: h}).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get block hash: %w", err)
+	}
+	if len(hashes) == 0 {
+		return "", nil
+	}
+	return hashes[0], nil
+}
+
+// GetRecentBlocks returns the highest recorded blocks, highest first
+func (r *RedisClient) GetRecentBlocks(limit int64) ([]BlockRef, error) {
+	entries, err := r.client.ZRevRangeWithScores(r.ctx, "bitpic:sync:blocks", 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent blocks: %w", err)
+	}
+	blocks := make([]BlockRef, 0, len(entries))
+	for _, e := range entries {
+		hash, _ := e.Member.(string)
+		blocks = append(blocks, BlockRef{Height: uint64(e.Score), Hash: hash})
+	}
+	return blocks, nil
+}
+
+// unconfirmAvatarScript strips the confirmation from one history record and,
+// if it is the paymail's current avatar, from the current and meta keys too.
+// Returns the updated record, or nil if the record no longer exists.
+//
+// KEYS: current, meta, history data, confirmed index
+// ARGV: outpoint, confirmed index member
+var unconfirmAvatarScript = redis.NewScript(`
+redis.call('ZREM', KEYS[4], ARGV[2])
+local rec = redis.call('HGET', KEYS[3], ARGV[1])
+if not rec then
+	return false
+end
+local data = cjson.decode(rec)
+data.confirmed = false
+data.blockHeight = nil
+data.blockHash = nil
+rec = cjson.encode(data)
+redis.call('HSET', KEYS[3], ARGV[1], rec)
+
+local cur = redis.call('GET', KEYS[1])
+if cur and cjson.decode(cur).outpoint == ARGV[1] then
+	redis.call('SET', KEYS[1], rec)
+	redis.call('SET', KEYS[2], rec)
+end
+return rec
+`)
+
+// RollbackBlocks unconfirms every avatar record confirmed at or above
+// fromHeight, forgets the recorded blocks from fromHeight up and moves the
+// sync cursor back below fromHeight. It returns the unconfirmed records.
+func (r *RedisClient) RollbackBlocks(fromHeight uint64) ([]AvatarData, error) {
+	from := strconv.FormatUint(fromHeight, 10)
+	members, err := r.client.ZRangeByScore(r.ctx, "bitpic:confirmed", &redis.ZRangeBy{Min: from, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get confirmed avatars: %w", err)
+	}
+
+	var affected []AvatarData
+	for _, member := range members {
+		outpoint, paymail, ok := strings.Cut(member, ":")
+		if !ok {
+			continue
+		}
+		keys := []string{
+			fmt.Sprintf("bitpic:current:%s", paymail),
+			fmt.Sprintf("bitpic:meta:%s", paymail),
+			fmt.Sprintf("bitpic:historydata:%s", paymail),
+			"bitpic:confirmed",
+		}
+		result, err := unconfirmAvatarScript.Run(r.ctx, r.client, keys, outpoint, member).Text()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return affected, fmt.Errorf("failed to unconfirm avatar: %w", err)
+		}
+		var data AvatarData
+		if err := json.Unmarshal([]byte(result), &data); err == nil {
+			affected = append(affected, data)
+		}
+	}
+
+	if err := r.client.ZRemRangeByScore(r.ctx, "bitpic:sync:blocks", from, "+inf").Err(); err != nil {
+		return affected, fmt.Errorf("failed to forget orphaned blocks: %w", err)
+	}
+
+	if last, err := r.GetLastBlock(); err == nil && last >= fromHeight {
+		if err := r.SetLastBlock(blockBefore(fromHeight)); err != nil {
+			return affected, err
+		}
+	}
+
+	return affected, nil
+}
+
 // GetTotalAvatars returns the total count of avatars
 func (r *RedisClient) GetTotalAvatars() (int64, error) {
 	pattern := "bitpic:current:*"
@@ -282,16 +598,6 @@ func (r *RedisClient) GetTotalAvatars() (int64, error) {
 	return count, nil
 }
 
-// PaymailData represents a registered paymail
-type PaymailData struct {
-	Handle         string `json:"handle"`
-	IdentityPubkey string `json:"identityPubkey"`
-	PaymentAddress string `json:"paymentAddress"`
-	OrdAddress     string `json:"ordAddress"`
-	PaymentTxid    string `json:"paymentTxid,omitempty"`
-	CreatedAt      int64  `json:"createdAt"`
-}
-
 // ClaimPaymentTxid atomically records a fee-payment txid, returning true only
 // the first time it is seen. Prevents a single fee payment from registering
 // more than one paymail.
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) *RedisClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	r, err := NewRedisClient("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// Concurrent writers with interleaved timestamps: whatever order the scripts
+// run in, the newest record is current and every index agrees on it.
+func TestRedisSetAvatarConcurrentNewestWins(t *testing.T) {
+	r := newTestRedis(t)
+	const paymail = "alice@example.com"
+	const writers = 64
+
+	records := make([]*AvatarData, writers)
+	for i := range records {
+		txid := fmt.Sprintf("%064x", i+1)
+		records[i] = &AvatarData{
+			Outpoint:  txid + "_0",
+			Timestamp: int64(1700000000 + i),
+			Paymail:   paymail,
+			TxID:      txid,
+			PubKey:    "02aa",
+		}
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(records), func(i, j int) {
+		records[i], records[j] = records[j], records[i]
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for _, rec := range records {
+		wg.Add(1)
+		go func(rec *AvatarData) {
+			defer wg.Done()
+			errs <- r.SetAvatar(rec)
+		}(rec)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("SetAvatar: %v", err)
+		}
+	}
+
+	newest := int64(1700000000 + writers - 1)
+	current, err := r.GetAvatarData(paymail)
+	if err != nil || current == nil {
+		t.Fatalf("GetAvatarData: %v, %v", current, err)
+	}
+	if current.Timestamp != newest {
+		t.Errorf("current timestamp = %d, want %d", current.Timestamp, newest)
+	}
+
+	metaJSON, err := r.client.Get(r.ctx, "bitpic:meta:"+paymail).Result()
+	if err != nil {
+		t.Fatalf("get meta: %v", err)
+	}
+	var meta AvatarData
+	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+		t.Fatalf("unmarshal meta: %v", err)
+	}
+	if meta.Outpoint != current.Outpoint {
+		t.Errorf("meta outpoint = %s, current = %s", meta.Outpoint, current.Outpoint)
+	}
+
+	score, err := r.client.ZScore(r.ctx, "bitpic:feed", paymail).Result()
+	if err != nil {
+		t.Fatalf("feed score: %v", err)
+	}
+	if int64(score) != newest {
+		t.Errorf("feed score = %d, want %d", int64(score), newest)
+	}
+
+	history, _, err := r.GetAvatarHistory(paymail, "", 100)
+	if err != nil {
+		t.Fatalf("GetAvatarHistory: %v", err)
+	}
+	if len(history) != writers {
+		t.Errorf("history has %d records, want %d", len(history), writers)
+	}
+
+	paymails, err := r.GetAvatarPaymailsByPubKey("02aa")
+	if err != nil {
+		t.Fatalf("GetAvatarPaymailsByPubKey: %v", err)
+	}
+	if len(paymails) != 1 || paymails[0] != paymail {
+		t.Errorf("pubkey paymails = %v, want [%s]", paymails, paymail)
+	}
+}
+
+// An older record arriving after a newer one goes into history only.
+func TestRedisSetAvatarOlderDoesNotReplace(t *testing.T) {
+	r := newTestRedis(t)
+	newer := &AvatarData{Outpoint: "bb_0", TxID: "bb", Timestamp: 200, Paymail: "bob@example.com"}
+	older := &AvatarData{Outpoint: "aa_0", TxID: "aa", Timestamp: 100, Paymail: "bob@example.com"}
+	for _, rec := range []*AvatarData{newer, older} {
+		if err := r.SetAvatar(rec); err != nil {
+			t.Fatalf("SetAvatar: %v", err)
+		}
+	}
+
+	current, err := r.GetAvatarData("bob@example.com")
+	if err != nil || current == nil || current.Outpoint != "bb_0" {
+		t.Fatalf("current = %+v, %v; want bb_0", current, err)
+	}
+	items, total, err := r.GetFeed(0, 10, "")
+	if err != nil || total != 1 || len(items) != 1 || items[0].Outpoint != "bb_0" {
+		t.Fatalf("feed = %+v (total %d), %v; want bb_0", items, total, err)
+	}
+}
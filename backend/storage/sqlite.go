@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, registers "sqlite"
+)
+
+// SQLiteStore is a Store backed by an embedded SQLite database, for small
+// deployments that want persistence without running Redis.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS avatars (
+	paymail      TEXT PRIMARY KEY,
+	outpoint     TEXT NOT NULL,
+	txid         TEXT NOT NULL,
+	timestamp    INTEGER NOT NULL,
+	block_height INTEGER NOT NULL DEFAULT 0,
+	data         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS avatars_feed ON avatars (timestamp DESC, paymail DESC);
+
+CREATE TABLE IF NOT EXISTS avatar_history (
+	paymail      TEXT NOT NULL,
+	outpoint     TEXT NOT NULL,
+	sort_key     TEXT NOT NULL,
+	block_height INTEGER NOT NULL DEFAULT 0,
+	data         TEXT NOT NULL,
+	PRIMARY KEY (paymail, outpoint)
+);
+CREATE INDEX IF NOT EXISTS avatar_history_order ON avatar_history (paymail, sort_key DESC);
+CREATE INDEX IF NOT EXISTS avatar_history_block ON avatar_history (block_height);
+CREATE INDEX IF NOT EXISTS avatar_history_txid ON avatar_history (json_extract(data, '$.txid'));
+CREATE INDEX IF NOT EXISTS avatar_history_pubkey ON avatar_history (json_extract(data, '$.pubkey'));
+
+CREATE TABLE IF NOT EXISTS blocks (
+	height INTEGER PRIMARY KEY,
+	hash   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS images (
+	key        TEXT PRIMARY KEY,
+	data       BLOB NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS sync (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS paymails (
+	handle          TEXT PRIMARY KEY,
+	identity_pubkey TEXT NOT NULL,
+	payment_address TEXT NOT NULL,
+	ord_address     TEXT NOT NULL,
+	payment_txid    TEXT NOT NULL DEFAULT '',
+	created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS paymails_pubkey ON paymails (identity_pubkey COLLATE NOCASE);
+
+CREATE TABLE IF NOT EXISTS paid_txids (
+	txid TEXT PRIMARY KEY
+);
+`
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at path.
+// ":memory:" gives a private throwaway database.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, errors.New("sqlite path is required")
+	}
+
+	dsn := path
+	if path != ":memory:" {
+		dsn = "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	// SQLite serializes writers anyway; a single connection also keeps a
+	// :memory: database from being split across connections.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// SetAvatar stores avatar data for a paymail and records it in the paymail's
+// history. Records are stored as JSON (like the Redis store) next to the
+// columns the queries need. The newest-wins comparison is part of the upsert,
+// so the write is atomic.
+func (s *SQLiteStore) SetAvatar(data *AvatarData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal avatar data: %w", err)
+	}
+
+	var blockHeight uint64
+	if data.Confirmed {
+		blockHeight = data.BlockHeight
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to set avatar: %w", err)
+	}
+	defer tx.Rollback()
+
+	// History is keyed by outpoint: re-indexing the same output
+	// (mempool -> confirmed) replaces its entry.
+	_, err = tx.Exec(`
+		INSERT INTO avatar_history (paymail, outpoint, sort_key, block_height, data)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (paymail, outpoint) DO UPDATE SET
+			sort_key = excluded.sort_key,
+			block_height = excluded.block_height,
+			data = excluded.data`,
+		data.Paymail, data.Outpoint, historyCursor(data), int64(blockHeight), string(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to set avatar history: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO avatars (paymail, outpoint, txid, timestamp, block_height, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (paymail) DO UPDATE SET
+			outpoint = excluded.outpoint,
+			txid = excluded.txid,
+			timestamp = excluded.timestamp,
+			block_height = excluded.block_height,
+			data = excluded.data
+		WHERE avatars.txid = excluded.txid OR excluded.timestamp >= avatars.timestamp`,
+		data.Paymail, data.Outpoint, data.TxID, data.Timestamp, int64(blockHeight), string(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to set avatar: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetAvatarHistory returns a page of a paymail's avatar records, newest first,
+// starting after cursor ("" for the first page). The returned cursor is empty
+// on the last page.
+func (s *SQLiteStore) GetAvatarHistory(paymail, cursor string, limit int64) ([]AvatarData, string, error) {
+	query := `SELECT data FROM avatar_history WHERE paymail = ?`
+	args := []any{paymail}
+	if cursor != "" {
+		query += ` AND sort_key < ?`
+		args = append(args, cursor)
+	}
+	query += ` ORDER BY sort_key DESC LIMIT ?`
+	args = append(args, limit+1) // one extra to learn whether there is a next page
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get avatar history: %w", err)
+	}
+	defer rows.Close()
+
+	var items []AvatarData
+	for rows.Next() {
+		data, err := scanAvatar(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan avatar history: %w", err)
+		}
+		items = append(items, *data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to get avatar history: %w", err)
+	}
+
+	if int64(len(items)) <= limit {
+		return items, "", nil
+	}
+	items = items[:limit]
+	return items, historyCursor(&items[limit-1]), nil
+}
+
+// GetAvatarsByTxID returns the avatar records a transaction created, in
+// outpoint order
+func (s *SQLiteStore) GetAvatarsByTxID(txid string) ([]AvatarData, error) {
+	rows, err := s.db.Query(`SELECT data FROM avatar_history WHERE json_extract(data, '$.txid') = ? ORDER BY outpoint`, txid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatars by txid: %w", err)
+	}
+	defer rows.Close()
+
+	var items []AvatarData
+	for rows.Next() {
+		data, err := scanAvatar(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get avatars by txid: %w", err)
+		}
+		items = append(items, *data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get avatars by txid: %w", err)
+	}
+	return items, nil
+}
+
+// GetAvatarPaymailsByPubKey returns every paymail pubKey has signed an avatar for
+func (s *SQLiteStore) GetAvatarPaymailsByPubKey(pubKey string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT paymail FROM avatar_history WHERE json_extract(data, '$.pubkey') = ? ORDER BY paymail`, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get paymails by pubkey: %w", err)
+	}
+	defer rows.Close()
+
+	var paymails []string
+	for rows.Next() {
+		var paymail string
+		if err := rows.Scan(&paymail); err != nil {
+			return nil, fmt.Errorf("failed to get paymails by pubkey: %w", err)
+		}
+		paymails = append(paymails, paymail)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get paymails by pubkey: %w", err)
+	}
+	return paymails, nil
+}
+
+// RemoveAvatar deletes an avatar record. If it was the paymail's current
+// avatar, the newest remaining record takes its place. It returns the
+// paymail's current avatar afterwards, or nil if none is left.
+func (s *SQLiteStore) RemoveAvatar(paymail, outpoint string) (*AvatarData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove avatar: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM avatar_history WHERE paymail = ? AND outpoint = ?`, paymail, outpoint); err != nil {
+		return nil, fmt.Errorf("failed to remove avatar history: %w", err)
+	}
+
+	res, err := tx.Exec(`DELETE FROM avatars WHERE paymail = ? AND outpoint = ?`, paymail, outpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove avatar: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		// It was the current avatar: restore the newest remaining record.
+		_, err = tx.Exec(`
+			INSERT INTO avatars (paymail, outpoint, txid, timestamp, block_height, data)
+			SELECT paymail, outpoint, json_extract(data, '$.txid'), json_extract(data, '$.timestamp'), block_height, data
+			FROM avatar_history WHERE paymail = ? ORDER BY sort_key DESC LIMIT 1`, paymail)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore previous avatar: %w", err)
+		}
+	}
+
+	row := tx.QueryRow(`SELECT data FROM avatars WHERE paymail = ?`, paymail)
+	current, err := scanAvatar(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		current, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatar: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to remove avatar: %w", err)
+	}
+	return current, nil
+}
+
+// GetAvatar retrieves the current avatar outpoint for a paymail
+func (s *SQLiteStore) GetAvatar(paymail string) (string, error) {
+	data, err := s.GetAvatarData(paymail)
+	if err != nil || data == nil {
+		return "", err
+	}
+	return data.ContentOutpoint(), nil
+}
+
+// scanAvatar decodes a row's JSON data column.
+func scanAvatar(row interface{ Scan(...any) error }) (*AvatarData, error) {
+	var raw string
+	if err := row.Scan(&raw); err != nil {
+		return nil, err
+	}
+	var data AvatarData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal avatar data: %w", err)
+	}
+	return &data, nil
+}
+
+// GetAvatarData retrieves the full avatar data for a paymail
+func (s *SQLiteStore) GetAvatarData(paymail string) (*AvatarData, error) {
+	row := s.db.QueryRow(`SELECT data FROM avatars WHERE paymail = ?`, paymail)
+	data, err := scanAvatar(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatar: %w", err)
+	}
+	return data, nil
+}
+
+// Exists checks if an avatar exists for a paymail
+func (s *SQLiteStore) Exists(paymail string) (bool, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM avatars WHERE paymail = ?`, paymail).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to check existence: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetTotalAvatars returns the total count of avatars
+func (s *SQLiteStore) GetTotalAvatars() (int64, error) {
+	var n int64
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM avatars`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count avatars: %w", err)
+	}
+	return n, nil
+}
+
+// GetRecentPaymails returns the most recent paymails from the feed (newest first).
+func (s *SQLiteStore) GetRecentPaymails(limit int64) ([]string, error) {
+	rows, err := s.db.Query(`SELECT paymail FROM avatars ORDER BY timestamp DESC, paymail DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent paymails: %w", err)
+	}
+	defer rows.Close()
+
+	var paymails []string
+	for rows.Next() {
+		var paymail string
+		if err := rows.Scan(&paymail); err != nil {
+			return nil, fmt.Errorf("failed to scan paymail: %w", err)
+		}
+		paymails = append(paymails, paymail)
+	}
+	return paymails, rows.Err()
+}
+
+// GetFeed retrieves paginated feed items
+func (s *SQLiteStore) GetFeed(offset, limit int64, ordfsBaseURL string) ([]FeedItem, int64, error) {
+	total, err := s.GetTotalAvatars()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get feed count: %w", err)
+	}
+
+	rows, err := s.db.Query(`SELECT data FROM avatars
+		ORDER BY timestamp DESC, paymail DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get feed: %w", err)
+	}
+	defer rows.Close()
+
+	var items []FeedItem
+	for rows.Next() {
+		data, err := scanAvatar(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, feedItem(data, ordfsBaseURL))
+	}
+	return items, total, rows.Err()
+}
+
+// CacheImage stores an image in cache with TTL
+func (s *SQLiteStore) CacheImage(outpoint string, data []byte, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+	_, err := s.db.Exec(`INSERT INTO images (key, data, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`,
+		outpoint, data, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to cache image: %w", err)
+	}
+	return nil
+}
+
+// GetCachedImage retrieves a cached image
+func (s *SQLiteStore) GetCachedImage(outpoint string) ([]byte, error) {
+	var data []byte
+	var expiresAt int64
+	err := s.db.QueryRow(`SELECT data, expires_at FROM images WHERE key = ?`, outpoint).Scan(&data, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached image: %w", err)
+	}
+	if expiresAt != 0 && time.Now().Unix() >= expiresAt {
+		s.db.Exec(`DELETE FROM images WHERE key = ?`, outpoint)
+		return nil, nil
+	}
+	return data, nil
+}
+
+// SetLastBlock stores the last processed block height
+func (s *SQLiteStore) SetLastBlock(height uint64) error {
+	_, err := s.db.Exec(`INSERT INTO sync (key, value) VALUES ('lastBlock', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, int64(height))
+	if err != nil {
+		return fmt.Errorf("failed to set last block: %w", err)
+	}
+	return nil
+}
+
+// GetLastBlock retrieves the last processed block height
+func (s *SQLiteStore) GetLastBlock() (uint64, error) {
+	var height int64
+	err := s.db.QueryRow(`SELECT value FROM sync WHERE key = 'lastBlock'`).Scan(&height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last block: %w", err)
+	}
+	return uint64(height), nil
+}
+
+// SetBlockHash records the hash of a processed block
+func (s *SQLiteStore) SetBlockHash(height uint64, hash string) error {
+	_, err := s.db.Exec(`INSERT INTO blocks (height, hash) VALUES (?, ?)
+		ON CONFLICT (height) DO UPDATE SET hash = excluded.hash`, int64(height), hash)
+	if err != nil {
+		return fmt.Errorf("failed to set block hash: %w", err)
+	}
+	return nil
+}
+
+// GetBlockHash returns the recorded hash for a height, or "" if none
+func (s *SQLiteStore) GetBlockHash(height uint64) (string, error) {
+	var hash string
+	err := s.db.QueryRow(`SELECT hash FROM blocks WHERE height = ?`, int64(height)).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get block hash: %w", err)
+	}
+	return hash, nil
+}
+
+// GetRecentBlocks returns the highest recorded blocks, highest first
+func (s *SQLiteStore) GetRecentBlocks(limit int64) ([]BlockRef, error) {
+	rows, err := s.db.Query(`SELECT height, hash FROM blocks ORDER BY height DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []BlockRef
+	for rows.Next() {
+		var b BlockRef
+		var height int64
+		if err := rows.Scan(&height, &b.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		b.Height = uint64(height)
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// RollbackBlocks unconfirms every avatar record confirmed at or above
+// fromHeight, forgets the recorded blocks from fromHeight up and moves the
+// sync cursor back below fromHeight. It returns the unconfirmed records.
+func (s *SQLiteStore) RollbackBlocks(fromHeight uint64) ([]AvatarData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back blocks: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT data FROM avatar_history WHERE block_height > 0 AND block_height >= ?`, int64(fromHeight))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get confirmed avatars: %w", err)
+	}
+	var affected []AvatarData
+	for rows.Next() {
+		data, err := scanAvatar(rows)
+		if err != nil {
+			continue
+		}
+		unconfirm(data)
+		affected = append(affected, *data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get confirmed avatars: %w", err)
+	}
+
+	for i := range affected {
+		data := &affected[i]
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal avatar data: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE avatar_history SET block_height = 0, data = ? WHERE paymail = ? AND outpoint = ?`,
+			string(jsonData), data.Paymail, data.Outpoint); err != nil {
+			return nil, fmt.Errorf("failed to unconfirm avatar: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE avatars SET block_height = 0, data = ? WHERE paymail = ? AND outpoint = ?`,
+			string(jsonData), data.Paymail, data.Outpoint); err != nil {
+			return nil, fmt.Errorf("failed to unconfirm avatar: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM blocks WHERE height >= ?`, int64(fromHeight)); err != nil {
+		return nil, fmt.Errorf("failed to forget orphaned blocks: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE sync SET value = ? WHERE key = 'lastBlock' AND value >= ?`,
+		int64(blockBefore(fromHeight)), int64(fromHeight)); err != nil {
+		return nil, fmt.Errorf("failed to rewind last block: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to roll back blocks: %w", err)
+	}
+	return affected, nil
+}
+
+// ClaimPaymentTxid atomically records a fee-payment txid, returning true only
+// the first time it is seen.
+func (s *SQLiteStore) ClaimPaymentTxid(txid string) (bool, error) {
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO paid_txids (txid) VALUES (?)`, txid)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim payment txid: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim payment txid: %w", err)
+	}
+	return n == 1, nil
+}
+
+// SetPaymail stores a paymail record
+func (s *SQLiteStore) SetPaymail(data *PaymailData) error {
+	if data.CreatedAt == 0 {
+		data.CreatedAt = time.Now().Unix()
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO paymails (handle, identity_pubkey, payment_address, ord_address, payment_txid, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (handle) DO UPDATE SET
+			identity_pubkey = excluded.identity_pubkey,
+			payment_address = excluded.payment_address,
+			ord_address = excluded.ord_address,
+			payment_txid = excluded.payment_txid,
+			created_at = excluded.created_at`,
+		data.Handle, data.IdentityPubkey, data.PaymentAddress, data.OrdAddress, data.PaymentTxid, data.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set paymail: %w", err)
+	}
+	return nil
+}
+
+const paymailColumns = `handle, identity_pubkey, payment_address, ord_address, payment_txid, created_at`
+
+func (s *SQLiteStore) queryPaymail(where string, arg any) (*PaymailData, error) {
+	var data PaymailData
+	err := s.db.QueryRow(`SELECT `+paymailColumns+` FROM paymails WHERE `+where+` LIMIT 1`, arg).Scan(
+		&data.Handle, &data.IdentityPubkey, &data.PaymentAddress, &data.OrdAddress, &data.PaymentTxid, &data.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get paymail: %w", err)
+	}
+	return &data, nil
+}
+
+// GetPaymail retrieves a paymail record
+func (s *SQLiteStore) GetPaymail(handle string) (*PaymailData, error) {
+	return s.queryPaymail(`handle = ?`, handle)
+}
+
+// GetPaymailByPubkey looks up a paymail by identity pubkey
+func (s *SQLiteStore) GetPaymailByPubkey(pubkey string) (*PaymailData, error) {
+	return s.queryPaymail(`identity_pubkey = ? COLLATE NOCASE`, pubkey)
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
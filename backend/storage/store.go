@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store is the persistence layer behind the indexer and the HTTP API. Redis is
+// the production backend; the in-memory and SQLite stores let the indexer run
+// in tests and small deployments without a Redis server.
+type Store interface {
+	// Avatars
+	SetAvatar(data *AvatarData) error
+	GetAvatar(paymail string) (string, error)
+	GetAvatarData(paymail string) (*AvatarData, error)
+	Exists(paymail string) (bool, error)
+	GetTotalAvatars() (int64, error)
+	GetAvatarHistory(paymail, cursor string, limit int64) ([]AvatarData, string, error)
+	GetAvatarsByTxID(txid string) ([]AvatarData, error)
+	GetAvatarPaymailsByPubKey(pubKey string) ([]string, error)
+	RemoveAvatar(paymail, outpoint string) (*AvatarData, error)
+
+	// Feed
+	GetRecentPaymails(limit int64) ([]string, error)
+	GetFeed(offset, limit int64, ordfsBaseURL string) ([]FeedItem, int64, error)
+
+	// Image cache
+	CacheImage(outpoint string, data []byte, ttl time.Duration) error
+	GetCachedImage(outpoint string) ([]byte, error)
+
+	// Sync cursor
+	SetLastBlock(height uint64) error
+	GetLastBlock() (uint64, error)
+
+	// Chain tracking (reorg detection)
+	SetBlockHash(height uint64, hash string) error
+	GetBlockHash(height uint64) (string, error)
+	GetRecentBlocks(limit int64) ([]BlockRef, error)
+	RollbackBlocks(fromHeight uint64) ([]AvatarData, error)
+
+	// Paymails
+	ClaimPaymentTxid(txid string) (bool, error)
+	SetPaymail(data *PaymailData) error
+	GetPaymail(handle string) (*PaymailData, error)
+	GetPaymailByPubkey(pubkey string) (*PaymailData, error)
+
+	Close() error
+}
+
+// AvatarData represents avatar metadata held by a Store
+type AvatarData struct {
+	Outpoint  string `json:"outpoint"`
+	Timestamp int64  `json:"timestamp"`
+	Paymail   string `json:"paymail"`
+	TxID      string `json:"txid"`
+	Confirmed bool   `json:"confirmed"`
+	IsRef     bool   `json:"isRef,omitempty"`     // True if this points to an ordinal
+	RefOrigin string `json:"refOrigin,omitempty"` // The ordinal origin being referenced
+	ImageHash string `json:"imageHash,omitempty"` // SHA-256 (hex) the image must have: a signed embed's, or a c:// reference's
+
+	// Every signed URI of a uri-list reference, in order; the first is
+	// RefOrigin/ImageHash. (omitempty: Redis's cjson turns [] into {}.)
+	RefURIs []string `json:"refUris,omitempty"`
+
+	// Identity key (compressed, lowercase hex) the BitPic tape was signed with
+	PubKey string `json:"pubkey,omitempty"`
+
+	// Whether the paymail's host vouches for the signing key: verified,
+	// mismatch or unknown (see package bsvalias); empty if not checked.
+	Owner string `json:"owner,omitempty"`
+
+	// Block the tx was mined in; set only while Confirmed.
+	BlockHeight uint64 `json:"blockHeight,omitempty"`
+	BlockHash   string `json:"blockHash,omitempty"`
+}
+
+// ContentOutpoint returns the outpoint that holds the avatar image: the
+// referenced content for references, the BitPic tx output otherwise.
+func (a *AvatarData) ContentOutpoint() string {
+	if a.IsRef && a.RefOrigin != "" {
+		return a.RefOrigin
+	}
+	return a.Outpoint
+}
+
+// IsHashRef reports whether the avatar is a c:// reference: content known
+// only by its hash, with no outpoint to link to.
+func (a *AvatarData) IsHashRef() bool {
+	return a.IsRef && a.RefOrigin == "" && a.ImageHash != ""
+}
+
+// BlockRef is a processed block as the indexer saw it.
+type BlockRef struct {
+	Height uint64 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// FeedItem represents an item in the feed
+type FeedItem struct {
+	Paymail   string `json:"paymail"`
+	Outpoint  string `json:"outpoint"`
+	Timestamp int64  `json:"timestamp"`
+	URL       string `json:"url"`
+	TxID      string `json:"txid"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+// PaymailData represents a registered paymail
+type PaymailData struct {
+	Handle         string `json:"handle"`
+	IdentityPubkey string `json:"identityPubkey"`
+	PaymentAddress string `json:"paymentAddress"`
+	OrdAddress     string `json:"ordAddress"`
+	PaymentTxid    string `json:"paymentTxid,omitempty"`
+	CreatedAt      int64  `json:"createdAt"`
+}
+
+// Open returns the Store for a storage URL:
+//
+//	redis://host:6379, rediss://…   Redis
+//	sqlite:///path/to/bitpic.db     SQLite file (sqlite://:memory: for a throwaway db)
+//	memory://                       in-process maps, lost on exit
+func Open(storageURL string) (Store, error) {
+	switch {
+	case strings.HasPrefix(storageURL, "redis://"), strings.HasPrefix(storageURL, "rediss://"):
+		return NewRedisClient(storageURL)
+	case strings.HasPrefix(storageURL, "sqlite://"):
+		return NewSQLiteStore(strings.TrimPrefix(storageURL, "sqlite://"))
+	case storageURL == "memory://" || storageURL == "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage URL: %s", storageURL)
+	}
+}
+
+// CurrentAvatarByPubKey returns the most recently set current avatar signed
+// with pubKey, among every paymail the key has signed an avatar for, or nil.
+func CurrentAvatarByPubKey(store Store, pubKey string) (*AvatarData, error) {
+	pubKey = strings.ToLower(pubKey)
+	paymails, err := store.GetAvatarPaymailsByPubKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var newest *AvatarData
+	for _, paymail := range paymails {
+		current, err := store.GetAvatarData(paymail)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.PubKey != pubKey {
+			continue
+		}
+		if newest == nil || current.Timestamp > newest.Timestamp {
+			newest = current
+		}
+	}
+	return newest, nil
+}
+
+// NewestWins reports whether a record for txid at timestamp may replace the
+// existing avatar. A user's latest BitPic record is their avatar: an older
+// record (e.g. a historical re-sync) must not clobber a newer one, while
+// updates to the same tx (mempool -> confirmed) are always allowed.
+func NewestWins(existing *AvatarData, txid string, timestamp int64) bool {
+	if existing == nil {
+		return true
+	}
+	return existing.TxID == txid || timestamp >= existing.Timestamp
+}
+
+// unconfirm strips the confirmation from an avatar record whose block was
+// orphaned.
+func unconfirm(data *AvatarData) {
+	data.Confirmed = false
+	data.BlockHeight = 0
+	data.BlockHash = ""
+}
+
+// blockBefore returns the sync cursor after rolling back from height: the
+// block below it, or 0.
+func blockBefore(height uint64) uint64 {
+	if height == 0 {
+		return 0
+	}
+	return height - 1
+}
+
+// historyCursor returns the history sort key for an avatar record. Keys sort
+// lexically in history order (timestamp, then outpoint), so a key doubles as an
+// opaque pagination cursor: the next page is every record with a smaller key.
+func historyCursor(data *AvatarData) string {
+	return fmt.Sprintf("%019d:%s", data.Timestamp, data.Outpoint)
+}
+
+// outpointTxID returns the txid part of a txid_vout outpoint.
+func outpointTxID(outpoint string) string {
+	txid, _, _ := strings.Cut(outpoint, "_")
+	return txid
+}
+
+// feedItem converts stored avatar data into a feed entry.
+func feedItem(data *AvatarData, ordfsBaseURL string) FeedItem {
+	outpoint := data.ContentOutpoint()
+	item := FeedItem{
+		Paymail:   data.Paymail,
+		Outpoint:  outpoint,
+		Timestamp: data.Timestamp,
+		TxID:      data.TxID,
+		Confirmed: data.Confirmed,
+	}
+	// Hash references are only served by /u/:paymail
+	if !data.IsHashRef() {
+		item.URL = fmt.Sprintf("%s/%s", ordfsBaseURL, outpoint)
+	}
+	return item
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testStores returns one of each Store backend, empty
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "bitpic.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlite,
+		"redis":  newTestRedis(t),
+	}
+}
+
+func TestRollbackBlocksRewindsCursor(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.SetLastBlock(120); err != nil {
+				t.Fatalf("SetLastBlock: %v", err)
+			}
+			if _, err := store.RollbackBlocks(100); err != nil {
+				t.Fatalf("RollbackBlocks: %v", err)
+			}
+			if last, _ := store.GetLastBlock(); last != 99 {
+				t.Errorf("last block = %d, want 99", last)
+			}
+
+			// Rolling back from genesis must not wrap around
+			if _, err := store.RollbackBlocks(0); err != nil {
+				t.Fatalf("RollbackBlocks(0): %v", err)
+			}
+			if last, _ := store.GetLastBlock(); last != 0 {
+				t.Errorf("last block after rollback from 0 = %d, want 0", last)
+			}
+		})
+	}
+}
+
+func TestAvatarsByTxIDAfterRemove(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			txid := strings.Repeat("a", 64)
+			for i, paymail := range []string{"alice@example.com", "bob@example.com"} {
+				data := &AvatarData{Outpoint: fmt.Sprintf("%s_%d", txid, i), TxID: txid, Paymail: paymail, Timestamp: 100}
+				if err := store.SetAvatar(data); err != nil {
+					t.Fatalf("SetAvatar: %v", err)
+				}
+			}
+			if items, err := store.GetAvatarsByTxID(txid); err != nil || len(items) != 2 || items[0].Paymail != "alice@example.com" {
+				t.Fatalf("GetAvatarsByTxID = %+v, %v; want both outputs in order", items, err)
+			}
+
+			if _, err := store.RemoveAvatar("alice@example.com", txid+"_0"); err != nil {
+				t.Fatalf("RemoveAvatar: %v", err)
+			}
+			items, err := store.GetAvatarsByTxID(txid)
+			if err != nil || len(items) != 1 || items[0].Paymail != "bob@example.com" {
+				t.Errorf("GetAvatarsByTxID after remove = %+v, %v; want bob's only", items, err)
+			}
+			if items, _ := store.GetAvatarsByTxID(strings.Repeat("b", 64)); len(items) != 0 {
+				t.Errorf("unknown txid returned %+v", items)
+			}
+		})
+	}
+}
+
+func TestPaymailsByPubKeyAfterRemove(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			keyA, keyB := "02"+strings.Repeat("a", 64), "02"+strings.Repeat("b", 64)
+			records := []AvatarData{
+				{Paymail: "alice@example.com", PubKey: keyA, Timestamp: 100},
+				{Paymail: "alice@example.com", PubKey: keyA, Timestamp: 200},
+				{Paymail: "alice@example.com", PubKey: keyB, Timestamp: 300},
+				{Paymail: "bob@example.com", PubKey: keyA, Timestamp: 100},
+			}
+			for i := range records {
+				txid := fmt.Sprintf("%064x", i+1)
+				records[i].TxID, records[i].Outpoint = txid, txid+"_0"
+				if err := store.SetAvatar(&records[i]); err != nil {
+					t.Fatalf("SetAvatar: %v", err)
+				}
+			}
+			paymails := func(key string) []string {
+				t.Helper()
+				got, err := store.GetAvatarPaymailsByPubKey(key)
+				if err != nil {
+					t.Fatalf("GetAvatarPaymailsByPubKey: %v", err)
+				}
+				return got
+			}
+
+			// One of alice's two keyA records goes: she stays
+			if _, err := store.RemoveAvatar("alice@example.com", records[0].Outpoint); err != nil {
+				t.Fatal(err)
+			}
+			if got := paymails(keyA); !reflect.DeepEqual(got, []string{"alice@example.com", "bob@example.com"}) {
+				t.Errorf("keyA paymails = %v, want alice and bob", got)
+			}
+
+			// The last one goes: she leaves keyA's set, not keyB's
+			if _, err := store.RemoveAvatar("alice@example.com", records[1].Outpoint); err != nil {
+				t.Fatal(err)
+			}
+			if got := paymails(keyA); !reflect.DeepEqual(got, []string{"bob@example.com"}) {
+				t.Errorf("keyA paymails = %v, want bob only", got)
+			}
+			if got := paymails(keyB); !reflect.DeepEqual(got, []string{"alice@example.com"}) {
+				t.Errorf("keyB paymails = %v, want alice", got)
+			}
+
+			if _, err := store.RemoveAvatar("bob@example.com", records[3].Outpoint); err != nil {
+				t.Fatal(err)
+			}
+			if got := paymails(keyA); len(got) != 0 {
+				t.Errorf("keyA paymails = %v, want none", got)
+			}
+		})
+	}
+}